@@ -0,0 +1,51 @@
+package goorgeous
+
+import "fmt"
+
+// ParseError is a problem found in org content, such as a block that is never closed
+type ParseError struct {
+	// Line is the line of the input the problem belongs to, counting from 1
+	Line int
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("goorgeous: line %d: %s", e.Line, e.Msg)
+}
+
+// MultiError is returned by OrgWithOptions along with the output when the content
+// had problems and Options.Strict is not set
+type MultiError struct {
+	Errors []*ParseError
+	// Truncated is set when there were more problems than Options.MaxErrors and
+	// the rest were left out of Errors
+	Truncated bool
+}
+
+func (e *MultiError) Error() string {
+	switch len(e.Errors) {
+	case 0:
+		return "goorgeous: no errors"
+	case 1:
+		return e.Errors[0].Error()
+	}
+	if e.Truncated {
+		return fmt.Sprintf("%s (and more than %d more errors)", e.Errors[0], len(e.Errors)-1)
+	}
+	return fmt.Sprintf("%s (and %d more errors)", e.Errors[0], len(e.Errors)-1)
+}
+
+// report records a problem found at the 0-based line of the input. In strict mode
+// the problem is returned so rendering can stop.
+func (p *parser) report(line int, format string, args ...interface{}) error {
+	err := &ParseError{Line: line + 1, Msg: fmt.Sprintf(format, args...)}
+	if p.opts.Strict {
+		return err
+	}
+	if p.opts.MaxErrors > 0 && len(p.errs) >= p.opts.MaxErrors {
+		p.errsTruncated = true
+		return nil
+	}
+	p.errs = append(p.errs, err)
+	return nil
+}
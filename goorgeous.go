@@ -3,7 +3,15 @@ package goorgeous
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
 
 	"github.com/russross/blackfriday"
 	"github.com/shurcooL/sanitized_anchor_name"
@@ -12,14 +20,334 @@ import (
 type inlineParser func(p *parser, out *bytes.Buffer, data []byte, offset int) int
 
 type footnotes struct {
-	id  string
-	def string
+	id     string
+	def    string
+	number int
 }
 
+// footnotesByNumber sorts footnotes by the number they are shown with
+type footnotesByNumber []footnotes
+
+func (n footnotesByNumber) Len() int           { return len(n) }
+func (n footnotesByNumber) Less(i, j int) bool { return n[i].number < n[j].number }
+func (n footnotesByNumber) Swap(i, j int)      { n[i], n[j] = n[j], n[i] }
+
 type parser struct {
 	r              blackfriday.Renderer
 	inlineCallback [256]inlineParser
 	notes          []footnotes
+	opts           Options
+
+	// line is the index of the line being rendered
+	line int
+	// headlineIDs maps the line of each headline to its anchor and titleLines maps
+	// headline titles to the line of the first headline with that title
+	headlineIDs map[int]string
+	titleLines  map[string]int
+	// renderedIDs maps the line of each rendered headline to the id the renderer
+	// wrote for it, which [[*Title]] links are pointed at once rendering is done
+	renderedIDs map[int]string
+	// taken counts the anchors handed out by Options.SlugFunc
+	taken map[string]int
+	// minLevel is the level of the shallowest headline
+	minLevel int
+	// coderefs holds the labels of the (ref:name) markers found in source blocks
+	coderefs map[string]bool
+	// escapeText is set while the text being inline processed must be HTML escaped
+	escapeText bool
+	// errs holds the problems found in the content when rendering leniently
+	errs []*ParseError
+	// errsTruncated is set when problems were left out of errs by Options.MaxErrors
+	errsTruncated bool
+	// footnoteNumbers maps footnote names to their numbers when they are numbered
+	// in the order of their definitions
+	footnoteNumbers map[string]int
+	// footnoteDefs holds the names of the footnotes defined in the content; a
+	// reference to any other footnote is left as text
+	footnoteDefs map[string]bool
+	// inlineFootnotes counts the [fn::text] footnotes rendered so far
+	inlineFootnotes int
+	// footnoteRefs counts the references rendered so far to each footnote
+	footnoteRefs map[string]int
+	// scripts is how x^2 and x_2 are rendered, as Options.Scripts or else the ^: of
+	// #+OPTIONS: asks; it is never ScriptsByOptions
+	scripts ScriptMode
+	// paragraphOut and paragraphEnd are the buffer and length it had after the last
+	// unwrapped paragraph, to tell when Options.ParagraphBreak is due
+	paragraphOut *bytes.Buffer
+	paragraphEnd int
+}
+
+// Options controls how OrgWithOptions parses and renders org content
+type Options struct {
+	// LineEnding is written in place of every newline in the output. It must be
+	// either "\n" or "\r\n"; an empty LineEnding is treated as "\n".
+	LineEnding string
+
+	// TrimDocument drops the empty lines from the start of the output and leaves a
+	// single line ending at its end, so content starting with a table does not
+	// start with an empty line. Blank lines around the content never render as
+	// paragraphs either way.
+	TrimDocument bool
+
+	// MaxHeadlineDepth is the deepest headline level that is rendered as a
+	// headline; 0 means there is no limit. DeepHeadlines decides what happens
+	// to headlines below it.
+	MaxHeadlineDepth int
+	DeepHeadlines    DeepHeadlineMode
+
+	// SlugFunc, when set, creates the anchor of every headline from its title in place
+	// of the built-in slugger. taken holds how many times each anchor has been handed
+	// out so far so the function can keep anchors unique. Links such as [[*Title]]
+	// resolve to the anchor SlugFunc created for that title.
+	SlugFunc func(title string, taken map[string]int) string
+
+	// OmitHeadlineIDs renders headlines without an id attribute. Otherwise every
+	// headline gets the CUSTOM_ID of its property drawer, or an anchor made from its
+	// title, as its id; the renderer adds -1, -2 to repeated ids.
+	OmitHeadlineIDs bool
+
+	// HeadlineSelfLink adds an <a class="headline-anchor"> linking to the headline
+	// itself at the end of every headline that has an id, holding SelfLinkSymbol. The
+	// symbol is written as it is so it can be markup such as an icon; empty means ¶.
+	HeadlineSelfLink bool
+	SelfLinkSymbol   string
+
+	// TodoKeywords are the words that are taken as the state of a headline when they
+	// start its title, such as TODO in "* TODO write tests". The state is rendered as
+	// a <span class="todo TODO"> before the title. Empty means TODO and DONE.
+	TodoKeywords []string
+
+	// MarkdownHeadings also accepts Markdown style "# Heading" lines as headlines,
+	// with one # per level. A line starting with "# " is otherwise an org comment.
+	MarkdownHeadings bool
+
+	// ShowResults renders the #+RESULTS: that follow source blocks. Results of a
+	// block with :results silent are never rendered, whatever ShowResults is, and
+	// results of a block with :results output are rendered as raw text in a <pre>.
+	// The links in the results of a block with :results file are file links, so
+	// image paths among them are rendered as <img>, and relative file links in the
+	// results of a block with :dir are resolved against that directory.
+	ShowResults bool
+
+	// IntraWordEmphasis lets emphasis markers sit next to word characters, as in
+	// Markdown, so foo_bar_baz underlines bar. By default markers need the usual org
+	// boundary characters around them and foo_bar_baz stays as it is.
+	IntraWordEmphasis bool
+
+	// BaseHeadlineLevel, when set, renders the shallowest headline of the content at
+	// that level and every other headline relative to it, so a fragment whose
+	// headlines start at *** can be rendered from <h2> down. It must be between 0 and
+	// 6; 0 keeps the levels as they are. Levels past 6 are rendered as 6.
+	BaseHeadlineLevel int
+
+	// TrimBlockTrailingNewline drops one empty line from the end of the body of a
+	// source or example block so the empty line left before #+END_SRC does not show
+	// up in the <pre>. Empty lines inside the body are kept.
+	TrimBlockTrailingNewline bool
+
+	// TabWidth is the number of columns between tab stops when the indentation of
+	// list items is compared to nest them; 0 means 8. A tab indents to the next tab
+	// stop and mixed indentation is compared by the column it reaches, so with the
+	// default width "\t- b" is nested under "  - a" and "  - b" is not under "\t- a".
+	TabWidth int
+
+	// TabToSpaces, when above 0, replaces every tab in the rendered code of source
+	// and example blocks with that many spaces.
+	TabToSpaces int
+
+	// FootnoteOrder decides whether footnotes are numbered and listed in the order
+	// they are first referenced, or in the order of their definitions. A reference
+	// to a footnote that has no definition is rendered as the text it is written as.
+	FootnoteOrder FootnoteOrder
+
+	// AllowEmptyLinks renders links with an empty path, such as [[][desc]], as
+	// <a href=""> links. By default only their description is rendered, as text.
+	AllowEmptyLinks bool
+
+	// Highlighter, when set, is given the language and code of every source block and
+	// returns the highlighted HTML that is written inside its <pre><code>. When it
+	// returns an error, such as for a language it does not know, the code is
+	// rendered HTML escaped as it is without a Highlighter.
+	Highlighter func(lang string, code []byte) ([]byte, error)
+
+	// SrcBlockWrapper, when set, is called for every source block with its language
+	// and body to write markup around the block, such as a container with a copy
+	// button. renderInner writes the block as it is rendered otherwise. When the
+	// wrapper returns an error it is reported as a problem with the block and the
+	// block is rendered without it.
+	SrcBlockWrapper func(w io.Writer, lang string, body []byte, renderInner func() error) error
+
+	// InferLangFromShebang gives a source block without a language the language of
+	// the interpreter named on a #! line at the start of its body, so
+	// #!/usr/bin/env python is highlighted as python.
+	InferLangFromShebang bool
+
+	// DrawerMode decides whether drawers such as :PROPERTIES: and :LOGBOOK: are left
+	// out of the output or shown as preformatted text. DrawerRender, when set, is
+	// called with the name and lines of every drawer instead and its result is
+	// written to the output as it is. Properties are read by Subtree either way.
+	DrawerMode   DrawerMode
+	DrawerRender func(name string, lines []string) string
+
+	// OnUnresolvedLink, when set, is called for every link to a headline or coderef
+	// that is not in the content, such as [[*Missing]]. When it returns true its html
+	// is written in place of the link; otherwise the link is rendered as plain text.
+	OnUnresolvedLink func(link *Link) (html string, ok bool)
+
+	// CollapseSingleItemLists renders a - or + list with a single item as a paragraph
+	// holding the text of the item. Ordered lists, definition lists, items with a
+	// checkbox and items with a nested list are always rendered as lists.
+	CollapseSingleItemLists bool
+
+	// Underline decides the element _underlined_ text is rendered as. HTML has no
+	// element that only means underlined, so by default it is a <span class="underline">.
+	Underline UnderlineMode
+
+	// Scripts decides whether x^2 and H_2O are rendered with <sup> and <sub>. By
+	// default the ^: of #+OPTIONS: decides, and scripts are left as they are written
+	// when the content does not set it. Any other mode overrides #+OPTIONS:.
+	Scripts ScriptMode
+
+	// HorizontalRuleClass, when set, is rendered as the class of every <hr>.
+	HorizontalRuleClass string
+
+	// ParagraphTag is the element paragraphs are wrapped in; empty means p.
+	// UnwrapParagraphs writes the content of paragraphs without any element, for
+	// output that goes where a <p> may not, such as a <button>, with ParagraphBreak
+	// written between paragraphs that follow each other, for example "<br><br>".
+	ParagraphTag     string
+	UnwrapParagraphs bool
+	ParagraphBreak   string
+
+	// MaxInlineSpan is how many bytes past an emphasis, code or link opener are searched
+	// for its closer. An opener whose closer is further away is kept as a literal
+	// character. 0 means there is no limit.
+	MaxInlineSpan int
+
+	// Strict makes OrgWithOptions stop at the first problem in the content, such as a
+	// block without its #+END_ line, and return it as a *ParseError. Otherwise the
+	// problems are worked around and returned as a *MultiError along with the output.
+	Strict bool
+
+	// MaxTableColumns, when above 0, is how many cells of a table row are rendered.
+	// A row with more is cut down to that many and reported as a problem, so in
+	// strict mode it stops rendering. 0 means there is no limit.
+	MaxTableColumns int
+
+	// MaxErrors, when above 0, is how many problems are collected into the *MultiError
+	// when Strict is not set. Past it the rest are left out and the MultiError is marked
+	// as Truncated; the whole content is still rendered.
+	MaxErrors int
+}
+
+// Link is a link of org content, such as [[*A Heading][a description]]
+type Link struct {
+	// Path is the path of the link as written, such as *A Heading or (ref)
+	Path string
+	// Description is the description as written, or empty when there is none
+	Description string
+}
+
+// DeepHeadlineMode decides what happens to headlines deeper than Options.MaxHeadlineDepth
+type DeepHeadlineMode int
+
+const (
+	// DropDeepHeadlines leaves deep headlines and their content out of the output
+	DropDeepHeadlines DeepHeadlineMode = iota
+	// FlattenDeepHeadlines renders the title of a deep headline as a paragraph
+	// and keeps its content as part of the enclosing section
+	FlattenDeepHeadlines
+)
+
+// DrawerMode decides how drawers are rendered
+type DrawerMode int
+
+const (
+	// HideDrawers leaves drawers out of the output
+	HideDrawers DrawerMode = iota
+	// ShowDrawers renders the lines of a drawer in a <pre class="drawer name">
+	ShowDrawers
+)
+
+// UnderlineMode decides the element underlined text is rendered as
+type UnderlineMode int
+
+const (
+	// UnderlineSpan renders underlined text as a <span class="underline">
+	UnderlineSpan UnderlineMode = iota
+	// UnderlineU renders underlined text as a <u>
+	UnderlineU
+	// UnderlineIns renders underlined text as an <ins>
+	UnderlineIns
+)
+
+// ScriptMode decides which subscripts and superscripts are rendered
+type ScriptMode int
+
+const (
+	// ScriptsByOptions follows the ^: of the #+OPTIONS: of the content
+	ScriptsByOptions ScriptMode = iota
+	// ScriptsOn renders both x^2 and x^{2}, like ^:t
+	ScriptsOn
+	// ScriptsBraced renders only x^{2}, like ^:{}
+	ScriptsBraced
+	// ScriptsOff leaves every x^2 and x_2 as it is written, like ^:nil
+	ScriptsOff
+)
+
+// FootnoteOrder is the order footnotes are numbered and listed in
+type FootnoteOrder int
+
+const (
+	// FootnotesByReference numbers every footnote reference in the order it appears
+	FootnotesByReference FootnoteOrder = iota
+	// FootnotesByDefinition numbers footnotes in the order their definitions appear,
+	// giving every reference to a footnote the same number.
+	FootnotesByDefinition
+)
+
+// DefaultOptions returns the Options used by Org, OrgCommon and OrgOptions
+func DefaultOptions() Options {
+	return Options{
+		LineEnding:               "\n",
+		TrimDocument:             true,
+		ShowResults:              true,
+		TrimBlockTrailingNewline: true,
+		MaxInlineSpan:            4096,
+	}
+}
+
+var reTagName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+func (opts Options) validate() error {
+	switch opts.LineEnding {
+	case "", "\n", "\r\n":
+	default:
+		return fmt.Errorf("goorgeous: unsupported line ending %q", opts.LineEnding)
+	}
+	if opts.TabToSpaces < 0 {
+		return fmt.Errorf("goorgeous: negative TabToSpaces %d", opts.TabToSpaces)
+	}
+	if opts.TabWidth < 0 {
+		return fmt.Errorf("goorgeous: negative TabWidth %d", opts.TabWidth)
+	}
+	if opts.MaxInlineSpan < 0 {
+		return fmt.Errorf("goorgeous: negative MaxInlineSpan %d", opts.MaxInlineSpan)
+	}
+	if opts.ParagraphTag != "" && !reTagName.MatchString(opts.ParagraphTag) {
+		return fmt.Errorf("goorgeous: ParagraphTag %q is not an element name", opts.ParagraphTag)
+	}
+	if opts.MaxTableColumns < 0 {
+		return fmt.Errorf("goorgeous: negative MaxTableColumns %d", opts.MaxTableColumns)
+	}
+	if opts.MaxErrors < 0 {
+		return fmt.Errorf("goorgeous: negative MaxErrors %d", opts.MaxErrors)
+	}
+	if opts.BaseHeadlineLevel < 0 || opts.BaseHeadlineLevel > 6 {
+		return fmt.Errorf("goorgeous: base headline level %d is not between 0 and 6", opts.BaseHeadlineLevel)
+	}
+	return nil
 }
 
 // NewParser returns a new parser with the inlineCallbacks required for org content
@@ -34,6 +362,7 @@ func NewParser(renderer blackfriday.Renderer) *parser {
 	p.inlineCallback['*'] = generateBold
 	p.inlineCallback['+'] = generateStrikethrough
 	p.inlineCallback['['] = generateLinkOrImg
+	p.inlineCallback['^'] = generateSuperscript
 
 	return p
 }
@@ -45,6 +374,15 @@ func OrgCommon(input []byte) []byte {
 	return OrgOptions(input, renderer)
 }
 
+// ContentHash returns a hex encoded SHA-256 of the HTML that OrgCommon renders for
+// input, for use as a cache key. Inputs that render to the same HTML have the same
+// hash, so differences such as table padding or keywords that are not rendered
+// do not change it.
+func ContentHash(input []byte) string {
+	sum := sha256.Sum256(OrgCommon(input))
+	return hex.EncodeToString(sum[:])
+}
+
 // Org is a convenience name for OrgOptions
 func Org(input []byte, renderer blackfriday.Renderer) []byte {
 	return OrgOptions(input, renderer)
@@ -52,140 +390,453 @@ func Org(input []byte, renderer blackfriday.Renderer) []byte {
 
 // OrgOptions takes an org content byte slice and a renderer to use
 func OrgOptions(input []byte, renderer blackfriday.Renderer) []byte {
+	out, _ := OrgWithOptions(input, renderer, DefaultOptions())
+	return out
+}
+
+// OrgWithOptions takes an org content byte slice, a renderer to use and the Options
+// to parse and render with. It returns an error if the options are invalid, and a
+// *ParseError or *MultiError describing problems in the content depending on Options.Strict.
+func OrgWithOptions(input []byte, renderer blackfriday.Renderer, opts Options) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
 	// in the case that we need to render something in isEmpty but there isn't a new line char
 	input = append(input, '\n')
 	var output bytes.Buffer
 
 	p := NewParser(renderer)
+	p.opts = opts
+	p.collectScriptOption(input)
+	p.collectHeadlineIDs(input)
+	p.collectCoderefs(input)
+	p.collectFootnotes(input)
 
 	scanner := bufio.NewScanner(bytes.NewReader(input))
 	// used to capture code blocks
 	marker := ""
 	syntax := ""
+	// the line of the #+BEGIN_ of the block being collected
+	blockLine := 0
+	// the blocks opened inside the body of a source or example block, such as the
+	// #+BEGIN_QUOTE of an org example, whose #+END_ lines are part of the body
+	var verbatimBlocks []string
+	// quoteStack holds the content of the quote blocks a nested quote block is in
+	var quoteStack [][]byte
 	listType := ""
+	listBullet := byte(0)
 	inParagraph := false
 	inList := false
 	inTable := false
+	// caption holds a #+CAPTION: waiting for the table it belongs to
+	var caption, tableCaption []byte
 	inFixedWidthArea := false
 	inFootNote := false
 	curFootNoteId := ""
+	// footnoteText holds the definition of each footnote, whether it comes before or
+	// after the references to it
+	footnoteText := make(map[string]string)
 	var tmpBlock bytes.Buffer
+	// drawerName and drawerLines hold the drawer being collected while marker is
+	// drawerMarker, and drawerLine is the line it starts on
+	var drawerName string
+	var drawerLines []string
+	drawerLine := 0
+
+	// used to skip the sections of headlines deeper than MaxHeadlineDepth
+	dropping := false
+	var droppedBlocks blockTracker
+
+	// used to leave out the results of source blocks
+	var curSrc, lastSrc *SrcBlock
+	var results resultsReader
+	// resultsSrc is the block whose results are being rendered
+	var resultsSrc *SrcBlock
+
+	// listLevels holds the list being collected followed by the lists nested in it
+	var listLevels []listLevel
+	// listItems counts the items of the list being collected, nested ones included
+	listItems := 0
+	// itemText is the text of the last list item, rendered into tmpBlock from
+	// itemStart up to its closer at itemEnd, so the item can be rendered again as a
+	// whole when it continues on the next line. itemEnd is -1 when it cannot be.
+	var itemText []byte
+	itemStart, itemEnd := 0, -1
+	itemIsDefinition := false
+
+	// closeNestedList ends the innermost nested list and the item it is in
+	closeNestedList := func() {
+		level := listLevels[len(listLevels)-1]
+		listLevels = listLevels[:len(listLevels)-1]
+		tmpBlock.WriteString("</" + level.kind + ">\n" + listItemCloser(listLevels[len(listLevels)-1].kind))
+	}
 
-	for scanner.Scan() {
+	// flushBlock renders the list, table, paragraph or fixed width area being
+	// collected and reports whether there was one to end
+	flushBlock := func() bool {
+		switch {
+		case inList:
+			for len(listLevels) > 1 {
+				closeNestedList()
+			}
+			listLevels = nil
+			if p.opts.CollapseSingleItemLists && listType == "ul" && listItems == 1 && itemEnd >= 0 && !reCheckbox.Match(itemText) {
+				p.generateParagraph(&output, bytes.TrimRight(itemText, " \t"))
+			} else if tmpBlock.Len() > 0 {
+				p.generateList(&output, tmpBlock.Bytes(), listType)
+			}
+			listItems = 0
+			inList = false
+			listType = ""
+			listBullet = 0
+			tmpBlock.Reset()
+		case inTable:
+			if tmpBlock.Len() > 0 {
+				p.generateTable(&output, tmpBlock.Bytes(), tableCaption)
+			}
+			inTable = false
+			tableCaption = nil
+			tmpBlock.Reset()
+		case inParagraph:
+			if tmpBlock.Len() > 0 {
+				p.generateParagraph(&output, tmpBlock.Bytes()[:len(tmpBlock.Bytes())-1])
+			}
+			inParagraph = false
+			tmpBlock.Reset()
+		case inFixedWidthArea:
+			if tmpBlock.Len() > 0 {
+				tmpBlock.WriteString("</pre>\n")
+				output.Write(tmpBlock.Bytes())
+			}
+			inFixedWidthArea = false
+			tmpBlock.Reset()
+		case inFootNote:
+			inFootNote = false
+			curFootNoteId = ""
+		default:
+			return false
+		}
+		return true
+	}
+
+	// closeNestedQuote renders the innermost nested quote block into the quote it is in
+	closeNestedQuote := func() {
+		var nested bytes.Buffer
+		p.r.BlockQuote(&nested, tmpBlock.Bytes())
+		tmpBlock.Reset()
+		tmpBlock.Write(quoteStack[len(quoteStack)-1])
+		tmpBlock.Write(nested.Bytes())
+		quoteStack = quoteStack[:len(quoteStack)-1]
+	}
+
+	// closeBlock renders the block being collected
+	closeBlock := func() error {
+		var err error
+		switch marker {
+		// the lines of quote and center blocks have already been inline processed
+		case "QUOTE":
+			for len(quoteStack) > 0 {
+				closeNestedQuote()
+			}
+			p.r.BlockQuote(&output, tmpBlock.Bytes())
+		case "CENTER":
+			output.WriteString("<center>\n")
+			output.Write(tmpBlock.Bytes())
+			output.WriteString("</center>\n")
+		case "SRC":
+			lang := syntax
+			if lang == "" && p.opts.InferLangFromShebang {
+				lang = shebangLang(tmpBlock.Bytes())
+			}
+			p.trimBlockNewline(&tmpBlock)
+			tmpBlock.WriteByte('\n')
+			renderInner := func(out *bytes.Buffer) error {
+				start := out.Len()
+				p.generateCode(out, p.expandTabs(tmpBlock.Bytes()), lang)
+				code := markCoderefs(out.Bytes()[start:])
+				out.Truncate(start)
+				out.Write(code)
+				return nil
+			}
+			if p.opts.SrcBlockWrapper == nil {
+				renderInner(&output)
+				break
+			}
+			var wrapped bytes.Buffer
+			wrapErr := p.opts.SrcBlockWrapper(&wrapped, lang, tmpBlock.Bytes(), func() error {
+				return renderInner(&wrapped)
+			})
+			if wrapErr != nil {
+				err = p.report(blockLine, "SrcBlockWrapper: %v", wrapErr)
+				renderInner(&output)
+				break
+			}
+			if output.Len() > 0 {
+				output.WriteByte('\n')
+			}
+			output.Write(wrapped.Bytes())
+		case "VERSE":
+			p.generateVerse(&output, tmpBlock.Bytes())
+		// the body of an export block for html, or of the older #+BEGIN_HTML, is
+		// written as it is and export blocks for other backends are left out
+		case "HTML":
+			p.r.BlockHtml(&output, tmpBlock.Bytes())
+		case "EXPORT":
+			if bytes.EqualFold([]byte(syntax), []byte("html")) {
+				p.r.BlockHtml(&output, tmpBlock.Bytes())
+			}
+		case "EXAMPLE":
+			p.trimBlockNewline(&tmpBlock)
+			tmpBlock.WriteByte('\n')
+			p.generateExample(&output, p.expandTabs(tmpBlock.Bytes()))
+		default:
+			p.trimBlockNewline(&tmpBlock)
+			tmpBlock.WriteByte('\n')
+			p.r.BlockCode(&output, p.expandTabs(tmpBlock.Bytes()), syntax)
+		}
+		if marker == "SRC" {
+			lastSrc = curSrc
+		}
+		marker = ""
+		tmpBlock.Reset()
+		return err
+	}
+
+	// startList begins collecting a list, first ending a list of another type or
+	// bullet since org starts a new list when either of them changes. An item indented
+	// deeper than the one before it starts a list nested in that item, and an item
+	// indented less ends the nested lists it is not indented as deep as. It reports
+	// whether the item is the first of its list.
+	startList := func(kind string, bullet byte, data []byte) bool {
+		column := p.indentColumn(data)
+		for len(listLevels) > 1 && column < listLevels[len(listLevels)-1].column {
+			closeNestedList()
+		}
+		if len(listLevels) > 0 && column > listLevels[len(listLevels)-1].column {
+			closer := []byte(listItemCloser(listLevels[len(listLevels)-1].kind))
+			if bytes.HasSuffix(tmpBlock.Bytes(), closer) {
+				tmpBlock.Truncate(tmpBlock.Len() - len(closer))
+				tmpBlock.WriteString("\n<" + kind + ">\n")
+				listLevels = append(listLevels, listLevel{kind, column})
+				return true
+			}
+		}
+		if len(listLevels) > 1 {
+			level := &listLevels[len(listLevels)-1]
+			if level.kind == kind {
+				return false
+			}
+			tmpBlock.WriteString("</" + level.kind + ">\n<" + kind + ">\n")
+			level.kind = kind
+			return true
+		}
+		if inList && (listType != kind || listBullet != bullet) {
+			flushBlock()
+		}
+		if inList {
+			return false
+		}
+		// a paragraph or table right before the list ends where the list starts
+		flushBlock()
+		listType = kind
+		listBullet = bullet
+		inList = true
+		listLevels = []listLevel{{kind, column}}
+		return true
+	}
+
+	markListItem := func(text, rendered []byte, definition bool) {
+		itemText = append([]byte(nil), text...)
+		itemIsDefinition = definition
+		itemEnd = tmpBlock.Len() - len(listItemCloser(listLevels[len(listLevels)-1].kind))
+		itemStart = itemEnd - len(rendered)
+		if itemStart < 0 || !bytes.Equal(tmpBlock.Bytes()[itemStart:itemEnd], rendered) {
+			itemEnd = -1
+		}
+	}
+
+	// continueListItem adds a line indented deeper than the bullet of the last item to
+	// the text of that item and reports whether it did. The item is rendered again so
+	// emphasis and links can span its lines; they never reach into the next item.
+	continueListItem := func(data []byte) bool {
+		level := listLevels[len(listLevels)-1]
+		closer := []byte(listItemCloser(level.kind))
+		if p.indentColumn(data) <= level.column || !bytes.HasSuffix(tmpBlock.Bytes(), closer) {
+			return false
+		}
+		if itemEnd >= 0 && tmpBlock.Len() == itemEnd+len(closer) {
+			itemText = append(append(itemText, '\n'), bytes.TrimSpace(data)...)
+			tmpBlock.Truncate(itemStart)
+			var work bytes.Buffer
+			if itemIsDefinition {
+				p.inline(&work, itemText)
+			} else {
+				p.inlineListItem(&work, itemText)
+			}
+			tmpBlock.Write(work.Bytes())
+			tmpBlock.Write(closer)
+			markListItem(itemText, work.Bytes(), itemIsDefinition)
+			return true
+		}
+		tmpBlock.Truncate(tmpBlock.Len() - len(closer))
+		tmpBlock.WriteByte('\n')
+		p.inline(&tmpBlock, bytes.TrimSpace(data))
+		tmpBlock.Write(closer)
+		itemEnd = -1
+		return true
+	}
+
+	for line := 0; scanner.Scan(); line++ {
 		data := scanner.Bytes()
+		p.line = line
 
-		if !isEmpty(data) && isComment(data) || IsKeyword(data) {
-			switch {
-			case inList:
-				if tmpBlock.Len() > 0 {
-					p.generateList(&output, tmpBlock.Bytes(), listType)
-				}
-				inList = false
-				listType = ""
-				tmpBlock.Reset()
-			case inTable:
-				if tmpBlock.Len() > 0 {
-					p.generateTable(&output, tmpBlock.Bytes())
-				}
-				inTable = false
-				tmpBlock.Reset()
-			case inParagraph:
-				if tmpBlock.Len() > 0 {
-					p.generateParagraph(&output, tmpBlock.Bytes()[:len(tmpBlock.Bytes())-1])
-				}
-				inParagraph = false
-				tmpBlock.Reset()
-			case inFixedWidthArea:
-				if tmpBlock.Len() > 0 {
-					tmpBlock.WriteString("</pre>\n")
-					output.Write(tmpBlock.Bytes())
+		if p.opts.MarkdownHeadings && marker == "" {
+			data = markdownHeadingToHeadline(data)
+		}
+
+		if p.opts.MaxHeadlineDepth > 0 && marker == "" && !droppedBlocks.open() && isHeadline(data) {
+			dropping = false
+			if headlineLevel(data) > p.opts.MaxHeadlineDepth {
+				flushBlock()
+				if p.opts.DeepHeadlines == FlattenDeepHeadlines {
+					p.generateFlattenedHeadline(&output, data)
+				} else {
+					dropping = true
 				}
-				inFixedWidthArea = false
-				tmpBlock.Reset()
-			case inFootNote:
-				inFootNote = false
-				curFootNoteId = ""
+				continue
 			}
-
+		}
+		if dropping {
+			droppedBlocks.line(data)
+			continue
 		}
 
-		switch {
-		case isEmpty(data):
+		if results.read(data) {
+			continue
+		}
+		if len(results.lines) > 0 {
+			p.generateRawResults(&output, results.lines)
+			results.lines = nil
+		}
+		if marker == "" && isResults(data) {
 			switch {
-			case inList:
-				if tmpBlock.Len() > 0 {
-					p.generateList(&output, tmpBlock.Bytes(), listType)
-				}
-				inList = false
-				listType = ""
-				tmpBlock.Reset()
-			case inTable:
-				if tmpBlock.Len() > 0 {
-					p.generateTable(&output, tmpBlock.Bytes())
-				}
-				inTable = false
-				tmpBlock.Reset()
-			case inParagraph:
-				if tmpBlock.Len() > 0 {
-					p.generateParagraph(&output, tmpBlock.Bytes()[:len(tmpBlock.Bytes())-1])
-				}
-				inParagraph = false
-				tmpBlock.Reset()
-			case inFixedWidthArea:
-				if tmpBlock.Len() > 0 {
-					tmpBlock.WriteString("</pre>\n")
-					output.Write(tmpBlock.Bytes())
-				}
-				inFixedWidthArea = false
-				tmpBlock.Reset()
-			case inFootNote:
-				inFootNote = false
-				curFootNoteId = ""
-			case marker != "":
-				tmpBlock.WriteByte('\n')
-			default:
+			case !p.opts.ShowResults || lastSrc != nil && lastSrc.hasResults("silent"):
+				flushBlock()
+				results.start(false)
 				continue
+			case lastSrc != nil && lastSrc.hasResults("output"):
+				flushBlock()
+				results.start(true)
+				continue
+			case lastSrc != nil:
+				resultsSrc = lastSrc
 			}
-		case isPropertyDrawer(data) || marker == "PROPERTIES":
-			if marker == "" {
-				marker = "PROPERTIES"
+		} else if isEmpty(data) {
+			resultsSrc = nil
+		} else if resultsSrc != nil {
+			data = resolveResultLinks(data, resultsSrc)
+		}
+		if marker == "" && !isEmpty(data) {
+			lastSrc = nil
+		}
+		if marker == "" && !IsKeyword(data) && !isTable(data) {
+			caption = nil
+		}
+
+		if !isEmpty(data) && isComment(data) || IsKeyword(data) {
+			flushBlock()
+		}
+
+		// a drawer cannot run past the next headline, so one without its :END: is
+		// closed there
+		if marker == drawerMarker && isHeadline(data) {
+			if err := p.report(drawerLine, "drawer :%s: has no :END:", drawerName); err != nil {
+				return nil, err
 			}
-			if bytes.Equal(data, []byte(":END:")) {
+			p.generateDrawer(&output, drawerName, drawerLines)
+			marker = ""
+		}
+
+		switch {
+		case marker == drawerMarker:
+			if bytes.EqualFold(bytes.TrimSpace(data), []byte(":END:")) {
+				p.generateDrawer(&output, drawerName, drawerLines)
 				marker = ""
+				continue
 			}
+			drawerLines = append(drawerLines, string(data))
 			continue
+		case marker == "" && isDrawer(data):
+			flushBlock()
+			marker = drawerMarker
+			drawerName = string(reDrawer.FindSubmatch(data)[1])
+			drawerLines = nil
+			drawerLine = line
+			continue
+		case isEmpty(data):
+			if !flushBlock() {
+				if marker == "" {
+					continue
+				}
+				tmpBlock.WriteByte('\n')
+			}
 		case isBlock(data) || marker != "":
-			matches := reBlock.FindSubmatch(data)
-			if len(matches) > 0 {
-				if string(matches[1]) == "END" {
-					switch marker {
-					case "QUOTE":
-						var tmpBuf bytes.Buffer
-						p.inline(&tmpBuf, tmpBlock.Bytes())
-						p.r.BlockQuote(&output, tmpBuf.Bytes())
-					case "CENTER":
-						var tmpBuf bytes.Buffer
-						output.WriteString("<center>\n")
-						p.inline(&tmpBuf, tmpBlock.Bytes())
-						output.Write(tmpBuf.Bytes())
-						output.WriteString("</center>\n")
-					default:
-						tmpBlock.WriteByte('\n')
-						p.r.BlockCode(&output, tmpBlock.Bytes(), syntax)
-					}
-					marker = ""
+			matches := findBlock(data)
+			verbatim := isVerbatimBlock(marker)
+			if len(matches) > 0 && marker == "QUOTE" && string(matches[2]) == "QUOTE" {
+				if string(matches[1]) == "BEGIN" {
+					quoteStack = append(quoteStack, append([]byte(nil), tmpBlock.Bytes()...))
 					tmpBlock.Reset()
 					continue
 				}
+				if len(quoteStack) > 0 {
+					closeNestedQuote()
+					continue
+				}
+			}
+			if len(matches) > 0 && verbatim && string(matches[1]) == "BEGIN" {
+				verbatimBlocks = append(verbatimBlocks, string(matches[2]))
+			} else if len(matches) > 0 && verbatim && string(matches[2]) != marker &&
+				len(verbatimBlocks) > 0 && verbatimBlocks[len(verbatimBlocks)-1] == string(matches[2]) {
+				verbatimBlocks = verbatimBlocks[:len(verbatimBlocks)-1]
+			} else if len(matches) > 0 {
+				if string(matches[1]) == "END" {
+					if string(matches[2]) == marker {
+						if err := closeBlock(); err != nil {
+							return nil, err
+						}
+						continue
+					}
+					if marker == "" {
+						if err := p.report(line, "#+END_%s has no #+BEGIN_%s", matches[2], matches[2]); err != nil {
+							return nil, err
+						}
+						continue
+					}
+					if err := p.report(line, "#+END_%s does not close the #+BEGIN_%s on line %d, expected #+END_%s", matches[2], marker, blockLine+1, marker); err != nil {
+						return nil, err
+					}
+				}
 
 			}
 			if marker != "" {
-				if marker != "SRC" && marker != "EXAMPLE" {
+				if marker == "VERSE" {
+					// verse lines are inline processed one by one when the block closes
+					tmpBlock.Write(bytes.TrimRight(data, " \t"))
+					tmpBlock.WriteByte('\n')
+				} else if marker == "EXPORT" || marker == "HTML" {
+					tmpBlock.Write(data)
+					tmpBlock.WriteByte('\n')
+				} else if marker != "SRC" && marker != "EXAMPLE" && isHorizontalRule(data) {
+					p.generateHorizontalRule(&tmpBlock)
+				} else if marker != "SRC" && marker != "EXAMPLE" {
 					var tmpBuf bytes.Buffer
-					tmpBuf.Write([]byte("<p>\n"))
-					p.inline(&tmpBuf, data)
+					open, close := p.paragraphTags()
+					tmpBuf.WriteString(open)
+					p.inline(&tmpBuf, bytes.TrimRight(data, " \t"))
 					tmpBuf.WriteByte('\n')
-					tmpBuf.Write([]byte("</p>\n"))
+					tmpBuf.WriteString(close)
 					tmpBlock.Write(tmpBuf.Bytes())
 
 				} else {
@@ -198,81 +849,102 @@ func OrgOptions(input []byte, renderer blackfriday.Renderer) []byte {
 			} else {
 				marker = string(matches[2])
 				syntax = string(matches[3])
+				blockLine = line
+				verbatimBlocks = nil
+				quoteStack = nil
+				if marker == "SRC" {
+					block := parseSrcHeader(blockHeader(data))
+					curSrc = &block
+				}
 			}
 		case isFootnoteDef(data) || inFootNote:
 			if isFootnoteDef(data) {
 				matches := reFootnoteDef.FindSubmatch(data)
-				for i := range p.notes {
-					if p.notes[i].id == string(matches[1]) {
-						p.notes[i].def = string(matches[2])
-						inFootNote = true
-						curFootNoteId = string(matches[1])
-					}
-				}
-			} else if inFootNote {
-				for i := range p.notes {
-					if p.notes[i].id == curFootNoteId {
-						p.notes[i].def += " "
-						p.notes[i].def += string(data)
-					}
+				inFootNote = true
+				curFootNoteId = string(matches[1])
+				// the first definition of a footnote is the one that is kept
+				if _, ok := footnoteText[curFootNoteId]; ok {
+					curFootNoteId = ""
+				} else {
+					footnoteText[curFootNoteId] = string(matches[2])
 				}
+			} else if curFootNoteId != "" {
+				footnoteText[curFootNoteId] += " " + string(data)
 			}
 		case isTable(data):
 			if inTable != true {
+				flushBlock()
 				inTable = true
+				tableCaption = caption
+				caption = nil
+			}
+			if row := bytes.TrimSpace(data); p.opts.MaxTableColumns > 0 && !reTableHeaders.Match(row) {
+				if n := len(splitTableRow(row)); n > p.opts.MaxTableColumns {
+					if err := p.report(line, "table row has %d columns, more than the %d of MaxTableColumns", n, p.opts.MaxTableColumns); err != nil {
+						return nil, err
+					}
+				}
 			}
 			tmpBlock.Write(data)
 			tmpBlock.WriteByte('\n')
 		case IsKeyword(data):
+			if matches := reCaption.FindSubmatch(data); matches != nil {
+				caption = append([]byte(nil), matches[1]...)
+			}
 			continue
 		case isComment(data):
 			p.generateComment(&output, data)
 		case isHeadline(data):
+			flushBlock()
 			p.generateHeadline(&output, data)
 		case isDefinitionList(data):
-			if inList != true {
-				listType = "dl"
-				inList = true
-			}
+			startList("dl", listBulletChar(data), data)
 			var work bytes.Buffer
 			flags := blackfriday.LIST_TYPE_DEFINITION
 			matches := reDefinitionList.FindSubmatch(data)
 			flags |= blackfriday.LIST_TYPE_TERM
-			p.inline(&work, matches[1])
+			p.inlineListItem(&work, matches[1])
 			p.r.ListItem(&tmpBlock, work.Bytes(), flags)
 			work.Reset()
 			flags &= ^blackfriday.LIST_TYPE_TERM
-			p.inline(&work, matches[2])
+			p.inline(&work, bytes.TrimRight(matches[2], " \t"))
 			p.r.ListItem(&tmpBlock, work.Bytes(), flags)
+			markListItem(bytes.TrimRight(matches[2], " \t"), work.Bytes(), true)
+			listItems++
 		case isUnorderedList(data):
-			if inList != true {
-				listType = "ul"
-				inList = true
-			}
+			startList("ul", listBulletChar(data), data)
 			matches := reUnorderedList.FindSubmatch(data)
 			var work bytes.Buffer
-			p.inline(&work, matches[2])
+			p.inlineListItem(&work, matches[2])
 			p.r.ListItem(&tmpBlock, work.Bytes(), 0)
+			markListItem(matches[2], work.Bytes(), false)
+			listItems++
 		case isOrderedList(data):
-			if inList != true {
-				listType = "ol"
-				inList = true
-			}
+			// 1. and 1) items make up the same list
+			first := startList("ol", '.', data)
 			matches := reOrderedList.FindSubmatch(data)
 			var work bytes.Buffer
 			tmpBlock.WriteString("<li")
-			if len(matches[2]) > 0 {
+			// a [@n] counter sets the number of its item, and the number of the first
+			// item is kept when the list does not start at 1
+			value := matches[3]
+			if len(value) == 0 && first && string(matches[2]) != "1" {
+				value = matches[2]
+			}
+			if len(value) > 0 {
 				tmpBlock.WriteString(" value=\"")
-				tmpBlock.Write(matches[2])
+				tmpBlock.Write(value)
 				tmpBlock.WriteString("\"")
-				matches[3] = matches[3][1:]
 			}
-			p.inline(&work, matches[3])
+			p.inlineListItem(&work, matches[4])
 			tmpBlock.WriteString(">")
 			tmpBlock.Write(work.Bytes())
 			tmpBlock.WriteString("</li>\n")
+			markListItem(matches[4], work.Bytes(), false)
+			listItems++
 		case isHorizontalRule(data):
-			p.r.HRule(&output)
+			flushBlock()
+			p.generateHorizontalRule(&output)
 		case isExampleLine(data):
 			if inParagraph == true {
 				if len(tmpBlock.Bytes()) > 0 {
@@ -286,10 +958,17 @@ func OrgOptions(input []byte, renderer blackfriday.Renderer) []byte {
 				inFixedWidthArea = true
 			}
 			matches := reExampleLine.FindSubmatch(data)
-			tmpBlock.Write(matches[1])
+			p.r.NormalText(&tmpBlock, matches[1])
 			tmpBlock.WriteString("\n")
 			break
 		default:
+			if inList {
+				if continueListItem(data) {
+					continue
+				}
+				// a line that does not belong to the items ends the list
+				flushBlock()
+			}
 			if inParagraph == false {
 				inParagraph = true
 				if inFixedWidthArea == true {
@@ -306,16 +985,30 @@ func OrgOptions(input []byte, renderer blackfriday.Renderer) []byte {
 		}
 	}
 
-	if len(tmpBlock.Bytes()) > 0 {
-		if inParagraph == true {
-			p.generateParagraph(&output, tmpBlock.Bytes()[:len(tmpBlock.Bytes())-1])
-		} else if inFixedWidthArea == true {
-			tmpBlock.WriteString("</pre>\n")
-			output.Write(tmpBlock.Bytes())
+	flushBlock()
+	if marker == drawerMarker {
+		if err := p.report(drawerLine, "drawer :%s: has no :END:", drawerName); err != nil {
+			return nil, err
+		}
+		p.generateDrawer(&output, drawerName, drawerLines)
+	} else if marker != "" {
+		if err := p.report(blockLine, "#+BEGIN_%s has no #+END_%s", marker, marker); err != nil {
+			return nil, err
+		}
+		if err := closeBlock(); err != nil {
+			return nil, err
 		}
 	}
 
 	// Writing footnote def. list
+	if p.footnoteNumbers != nil {
+		sort.Stable(footnotesByNumber(p.notes))
+	}
+	for i := range p.notes {
+		if def, ok := footnoteText[p.notes[i].id]; ok {
+			p.notes[i].def = def
+		}
+	}
 	if len(p.notes) > 0 {
 		flags := blackfriday.LIST_ITEM_BEGINNING_OF_LIST
 		p.r.Footnotes(&output, func() bool {
@@ -326,7 +1019,21 @@ func OrgOptions(input []byte, renderer blackfriday.Renderer) []byte {
 		})
 	}
 
-	return output.Bytes()
+	out := p.fillHeadlineLinks(output.Bytes())
+	if opts.TrimDocument {
+		out = bytes.TrimRight(bytes.TrimLeft(out, "\n"), "\n")
+		if len(out) > 0 {
+			out = append(out, '\n')
+		}
+	}
+	if opts.LineEnding != "" && opts.LineEnding != "\n" {
+		out = bytes.Replace(out, []byte("\n"), []byte(opts.LineEnding), -1)
+	}
+
+	if len(p.errs) > 0 {
+		return out, &MultiError{Errors: p.errs, Truncated: p.errsTruncated}
+	}
+	return out, nil
 }
 
 // Org Syntax has been broken up into 4 distinct sections based on
@@ -338,210 +1045,1101 @@ func OrgOptions(input []byte, renderer blackfriday.Renderer) []byte {
 
 // Headlines
 func isHeadline(data []byte) bool {
-	if !charMatches(data[0], '*') {
+	if len(data) == 0 || !charMatches(data[0], '*') {
 		return false
 	}
 	level := 0
-	for level < 6 && charMatches(data[level], '*') {
+	for level < 6 && level < len(data) && charMatches(data[level], '*') {
 		level++
 	}
-	return charMatches(data[level], ' ')
+	return level < len(data) && charMatches(data[level], ' ')
 }
 
-func (p *parser) generateHeadline(out *bytes.Buffer, data []byte) {
-	level := 1
-	status := ""
-	priority := ""
-
-	for level < 6 && data[level] == '*' {
+func headlineLevel(data []byte) int {
+	level := 0
+	for level < len(data) && charMatches(data[level], '*') {
 		level++
 	}
+	return level
+}
+
+// generateFlattenedHeadline renders a headline that is deeper than MaxHeadlineDepth
+// as a paragraph holding its title
+func (p *parser) generateFlattenedHeadline(out *bytes.Buffer, data []byte) {
+	title := data[skipChar(data, headlineLevel(data), ' '):]
+	if _, tagsFound := findTags(title, 0); tagsFound > 0 {
+		title = title[:tagsFound]
+	}
+	p.generateParagraph(out, bytes.TrimRight(title, " \t"))
+}
+
+// headline holds the parts of a headline line
+type headline struct {
+	level    int
+	status   string
+	priority string
+	// cookie is the priority as written, such as [#A] or [A]
+	cookie string
+	tags   []string
+	// title is everything after the status and priority, without the tags and
+	// surrounding whitespace
+	title []byte
+}
 
-	start := skipChar(data, level, ' ')
+// parseHeadline splits a headline line into its parts. keywords are the TODO keywords
+// to look for; nil means the default TODO and DONE.
+func parseHeadline(data []byte, keywords []string) headline {
+	h := headline{level: headlineLevel(data)}
 
-	data = data[start:]
+	data = data[skipChar(data, h.level, ' '):]
 	i := 0
 
 	// Check if has a status so it can be rendered as a separate span that can be hidden or
 	// modified with CSS classes
-	if hasStatus(data[i:4]) {
-		status = string(data[i:4])
-		i += 5 // one extra character for the next whitespace
+	if status := findStatus(data, keywords); status != "" {
+		h.status = status
+		i += len(status) + 1 // one extra character for the next whitespace
+	}
+
+	// Check if a priority cookie follows
+	if i < len(data) {
+		if matches := rePriority.FindSubmatch(data[i:]); matches != nil {
+			h.priority = string(matches[2]) + string(matches[3])
+			h.cookie = string(matches[1])
+			i += len(matches[0])
+		}
+	}
+
+	if i > len(data) {
+		i = len(data)
+	}
+
+	tags, tagsFound := findTags(data, i)
+	dataEnd := len(data)
+	if tagsFound > 0 {
+		h.tags = tags
+		dataEnd = tagsFound
+	}
+
+	h.title = bytes.Trim(data[i:dataEnd], " \t")
+
+	return h
+}
+
+var reMarkdownHeading = regexp.MustCompile(`^(#{1,6}) +\S`)
+
+// markdownHeadingToHeadline rewrites a Markdown style "## Heading" line as the
+// headline "** Heading" and returns any other line unchanged
+func markdownHeadingToHeadline(data []byte) []byte {
+	matches := reMarkdownHeading.FindSubmatch(data)
+	if matches == nil {
+		return data
+	}
+	return append(bytes.Repeat([]byte("*"), len(matches[1])), data[len(matches[1]):]...)
+}
+
+func (p *parser) generateHeadline(out *bytes.Buffer, data []byte) {
+	h := parseHeadline(data, p.opts.TodoKeywords)
+
+	headlineID, ok := p.headlineIDs[p.line]
+	if !ok {
+		headlineID = p.headlineID(h)
+	}
+
+	start := out.Len()
+	generate := func() bool {
+		// the renderer has written the opening tag by now, with the id it made unique
+		// and gave its HeaderIDPrefix and HeaderIDSuffix
+		renderedID := headlineID
+		if id, ok := writtenHeaderID(out.Bytes()[start:]); ok {
+			renderedID = id
+		}
+		if renderedID != "" {
+			p.renderedIDs[p.line] = renderedID
+		}
+
+		if h.status != "" {
+			out.WriteString("<span class=\"todo " + h.status + "\">" + h.status + "</span>")
+			out.WriteByte(' ')
+		}
+
+		if h.priority != "" {
+			out.WriteString("<span class=\"priority " + h.priority + "\">" + h.cookie + "</span>")
+			out.WriteByte(' ')
+		}
+
+		p.inline(out, h.title)
+
+		for _, tag := range h.tags {
+			out.WriteByte(' ')
+			out.WriteString("<span class=\"tags " + tag + "\">" + tag + "</span>")
+			out.WriteByte(' ')
+		}
+
+		if p.opts.HeadlineSelfLink && headlineID != "" {
+			symbol := p.opts.SelfLinkSymbol
+			if symbol == "" {
+				symbol = "¶"
+			}
+			if !bytes.HasSuffix(out.Bytes(), []byte(" ")) {
+				out.WriteByte(' ')
+			}
+			out.WriteString("<a class=\"headline-anchor\" href=\"#" + html.EscapeString(renderedID) + "\">")
+			out.WriteString(symbol)
+			out.WriteString("</a>")
+		}
+		return true
+	}
+
+	if p.opts.OmitHeadlineIDs {
+		headlineID = ""
+	}
+	p.r.Header(out, generate, p.renderedLevel(h.level), headlineID)
+}
+
+// writtenHeaderID returns the id of the opening tag of a headline that a renderer
+// wrote to tag
+func writtenHeaderID(tag []byte) (string, bool) {
+	start, end := idAttribute(tag)
+	if start < 0 {
+		return "", false
+	}
+	return string(tag[start:end]), true
+}
+
+// idAttribute returns where the value of the first id attribute of tag starts and
+// ends, or -1, -1 when it has none
+func idAttribute(tag []byte) (int, int) {
+	i := bytes.Index(tag, []byte(` id="`))
+	if i < 0 {
+		return -1, -1
+	}
+	start := i + len(` id="`)
+	end := bytes.IndexByte(tag[start:], '"')
+	if end < 0 {
+		return -1, -1
+	}
+	return start, start + end
+}
+
+// renderedLevel returns the level a headline is rendered at, following Options.BaseHeadlineLevel
+func (p *parser) renderedLevel(level int) int {
+	if p.opts.BaseHeadlineLevel == 0 || p.minLevel == 0 {
+		return level
+	}
+	level = level - p.minLevel + p.opts.BaseHeadlineLevel
+	if level > 6 {
+		return 6
+	}
+	return level
+}
+
+// headlineID returns the anchor for a headline, using Options.SlugFunc when it is set
+func (p *parser) headlineID(h headline) string {
+	if p.opts.SlugFunc == nil {
+		return sanitized_anchor_name.Create(string(p.scriptText(h.title)))
+	}
+
+	if p.taken == nil {
+		p.taken = make(map[string]int)
+	}
+	id := p.opts.SlugFunc(string(h.title), p.taken)
+	p.taken[id]++
+	return id
+}
+
+// collectHeadlineIDs finds the anchor of every headline before rendering starts so
+// that internal links can point at headlines further down the document. A CUSTOM_ID
+// property is used as the anchor as it is.
+func (p *parser) collectHeadlineIDs(input []byte) {
+	p.headlineIDs = make(map[int]string)
+	p.titleLines = make(map[string]int)
+	p.renderedIDs = make(map[int]string)
+	var blocks blockTracker
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	for line, data := range lines {
+		if blocks.line(data) {
+			continue
+		}
+		if p.opts.MarkdownHeadings {
+			data = markdownHeadingToHeadline(data)
+		}
+		if !isHeadline(data) {
+			continue
+		}
+
+		h := parseHeadline(data, p.opts.TodoKeywords)
+		if p.opts.MaxHeadlineDepth == 0 || h.level <= p.opts.MaxHeadlineDepth {
+			if p.minLevel == 0 || h.level < p.minLevel {
+				p.minLevel = h.level
+			}
+		}
+		id := customID(lines, line+1)
+		if id == "" {
+			id = p.headlineID(h)
+		}
+		p.headlineIDs[line] = id
+		if _, found := p.titleLines[string(h.title)]; !found {
+			p.titleLines[string(h.title)] = line
+		}
+	}
+}
+
+// customID returns the CUSTOM_ID of the property drawer starting at line start, or
+// an empty string when there is none
+func customID(lines [][]byte, start int) string {
+	for _, prop := range drawerProperties(lines, start) {
+		if prop[0] == "CUSTOM_ID" {
+			return prop[1]
+		}
+	}
+	return ""
+}
+
+// generateCode renders the code of a source block, through Options.Highlighter
+// when it is set
+func (p *parser) generateCode(out *bytes.Buffer, code []byte, lang string) {
+	if p.opts.Highlighter != nil {
+		if highlighted, err := p.opts.Highlighter(lang, code); err == nil {
+			if out.Len() > 0 {
+				out.WriteByte('\n')
+			}
+			out.WriteString("<pre><code")
+			if lang != "" {
+				out.WriteString(" class=\"language-" + html.EscapeString(lang) + "\"")
+			}
+			out.WriteString(">")
+			out.Write(highlighted)
+			out.WriteString("</code></pre>\n")
+			return
+		}
+	}
+	p.r.BlockCode(out, code, lang)
+}
+
+// trimBlockNewline drops the last newline of a block body when
+// Options.TrimBlockTrailingNewline is set
+func (p *parser) trimBlockNewline(body *bytes.Buffer) {
+	if b := body.Bytes(); p.opts.TrimBlockTrailingNewline && len(b) > 0 && b[len(b)-1] == '\n' {
+		body.Truncate(len(b) - 1)
+	}
+}
+
+// expandTabs replaces the tabs of block code with spaces following Options.TabToSpaces
+func (p *parser) expandTabs(code []byte) []byte {
+	if p.opts.TabToSpaces == 0 {
+		return code
+	}
+	return bytes.Replace(code, []byte("\t"), bytes.Repeat([]byte(" "), p.opts.TabToSpaces), -1)
+}
+
+var reCoderef = regexp.MustCompile(`\(ref:([-\w]+)\)`)
+
+// collectCoderefs finds the (ref:name) markers in the source blocks so [[(name)]]
+// links can be told apart from links to labels that do not exist
+func (p *parser) collectCoderefs(input []byte) {
+	p.coderefs = make(map[string]bool)
+	for _, block := range SrcBlocks(input) {
+		for _, matches := range reCoderef.FindAllSubmatch(block.Body, -1) {
+			p.coderefs[string(matches[1])] = true
+		}
+	}
+}
+
+// markCoderefs turns the (ref:name) markers of a rendered source block into the
+// anchors that [[(name)]] links point at
+func markCoderefs(code []byte) []byte {
+	return reCoderef.ReplaceAll(code, []byte(`<span id="coderef-${1}" class="coderef">(${1})</span>`))
+}
+
+var defaultTodoKeywords = []string{"TODO", "DONE"}
+
+// findStatus returns the keyword that data starts with as a whole word, so a title
+// such as "TODOist" has no status, or an empty string when there is none
+func findStatus(data []byte, keywords []string) string {
+	if len(keywords) == 0 {
+		keywords = defaultTodoKeywords
+	}
+	for _, keyword := range keywords {
+		if keyword == "" || !bytes.HasPrefix(data, []byte(keyword)) {
+			continue
+		}
+		if rest := data[len(keyword):]; len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t' {
+			return keyword
+		}
+	}
+	return ""
+}
+
+// rePriority matches the priority cookie at the start of a headline title: [#A]
+// through [#Z], a number such as [#1], or the short [A] to [C]
+var rePriority = regexp.MustCompile(`^(\[(?:#([A-Z]|[0-9]+)|([A-C]))\])(?:[ \t]+|$)`)
+
+var reTags = regexp.MustCompile(`(?:^|[ \t]+):((?:[\w@#%]+:)+)[ \t]*$`)
+
+// findTags returns the tags of a trailing :tag1:tag2: run in data after start, and
+// the index of the whitespace in front of the run. The index is 0 when there are no
+// tags, so a colon inside the title, as in "ratio 1 : 2", is left alone.
+func findTags(data []byte, start int) ([]string, int) {
+	tags := []string{}
+	if start > len(data) {
+		return tags, 0
+	}
+	loc := reTags.FindSubmatchIndex(data[start:])
+	if loc == nil || start+loc[0] == 0 {
+		return tags, 0
+	}
+	for _, tag := range bytes.Split(bytes.TrimSuffix(data[start+loc[2]:start+loc[3]], []byte(":")), []byte(":")) {
+		tags = append(tags, string(tag))
+	}
+	return tags, start + loc[0]
+}
+
+// Greater Elements
+// ~~ Definition Lists
+var reDefinitionList = regexp.MustCompile(`^\s*-\s+(.+?)\s+::\s+(.*)`)
+
+func isDefinitionList(data []byte) bool {
+	return reDefinitionList.Match(data)
+}
+
+// ~~ Example lines
+var reExampleLine = regexp.MustCompile(`^\s*:\s(\s*.*)|^\s*:$`)
+
+func isExampleLine(data []byte) bool {
+	return reExampleLine.Match(data)
+}
+
+// ~~ Ordered Lists
+var reOrderedList = regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(?:\[@(\d+)\]\s*)?(.+)`)
+
+func isOrderedList(data []byte) bool {
+	return reOrderedList.Match(data)
+}
+
+// ~~ Unordered Lists
+var reUnorderedList = regexp.MustCompile(`^(\s*)[-\+]\s+(.+)`)
+
+func isUnorderedList(data []byte) bool {
+	return reUnorderedList.Match(data)
+}
+
+var reCheckbox = regexp.MustCompile(`^\[([ xX-])\](?:\s+|$)`)
+
+// inlineListItem inline processes the text of a list item or definition term,
+// rendering a leading [ ], [X] or [-] checkbox as a disabled <input type="checkbox">
+func (p *parser) inlineListItem(out *bytes.Buffer, data []byte) {
+	data = bytes.TrimRight(data, " \t")
+	if matches := reCheckbox.FindSubmatch(data); matches != nil {
+		p.generateCheckboxInput(out, matches[1][0])
+		data = data[len(matches[0]):]
+		if len(data) > 0 {
+			out.WriteByte(' ')
+		}
+	}
+	p.inline(out, data)
+}
+
+// generateCheckboxInput renders the state of a checkbox, one of ' ', 'X', 'x' or '-',
+// as a disabled <input>
+func (p *parser) generateCheckboxInput(out *bytes.Buffer, state byte) {
+	out.WriteString("<input type=\"checkbox\"")
+	switch state {
+	case ' ':
+	case '-':
+		out.WriteString(" class=\"indeterminate\"")
+	default:
+		out.WriteString(" checked")
+	}
+	out.WriteString(" disabled")
+	if p.r.GetFlags()&blackfriday.HTML_USE_XHTML != 0 {
+		out.WriteString(" />")
+	} else {
+		out.WriteString(">")
+	}
+}
+
+// listLevel is a list being collected and the column its items are indented to
+type listLevel struct {
+	kind   string
+	column int
+}
+
+// listItemCloser returns the end of the last item written for a list of kind
+func listItemCloser(kind string) string {
+	if kind == "dl" {
+		return "</dd>\n"
+	}
+	return "</li>\n"
+}
+
+// indentColumn returns the column the text of a line starts at. A tab advances to
+// the next multiple of Options.TabWidth, so indentation mixing tabs and spaces is
+// compared by the column it reaches.
+func (p *parser) indentColumn(data []byte) int {
+	width := p.opts.TabWidth
+	if width == 0 {
+		width = 8
+	}
+
+	column := 0
+	for _, c := range data {
+		switch c {
+		case ' ':
+			column++
+		case '\t':
+			column += width - column%width
+		default:
+			return column
+		}
+	}
+	return column
+}
+
+// listBulletChar returns the bullet character of an unordered or definition list item
+func listBulletChar(data []byte) byte {
+	trimmed := bytes.TrimLeft(data, " \t")
+	if len(trimmed) == 0 {
+		return 0
+	}
+	return trimmed[0]
+}
+
+// ~~ Tables
+var reTableHeaders = regexp.MustCompile(`^[|+:-]*$`)
+
+// isTable reports whether data is a table row: a line starting with a |, after any
+// indentation
+func isTable(data []byte) bool {
+	data = bytes.TrimLeft(data, " \t")
+	return len(data) > 0 && charMatches(data[0], '|')
+}
+
+var reCaption = regexp.MustCompile(`(?i)^#\+CAPTION:\s*(.*?)\s*$`)
+
+// generateTable renders a table; a non-empty caption is rendered inside it as <caption>.
+// Rows with fewer cells than the widest row are filled up with empty cells, and the
+// cells of a column are aligned as the first separator row asks.
+func (p *parser) generateTable(output *bytes.Buffer, data []byte, caption []byte) {
+	var table bytes.Buffer
+	rows := bytes.Split(bytes.Trim(data, "\n"), []byte("\n"))
+	for i := range rows {
+		rows[i] = bytes.TrimSpace(rows[i])
 	}
+	hasTableHeaders := len(rows) > 1
+	if len(rows) > 1 {
+		hasTableHeaders = reTableHeaders.Match(rows[1])
+	}
+	tbodySet := false
+
+	columns := 0
+	var aligns []int
+	for _, row := range rows {
+		if !reTableHeaders.Match(row) {
+			if n := len(p.tableCells(row)); n > columns {
+				columns = n
+			}
+		} else if aligns == nil {
+			aligns = tableAlignments(row)
+		}
+	}
+
+	for idx, row := range rows {
+		var rowBuff bytes.Buffer
+		if hasTableHeaders && idx == 0 {
+			table.WriteString("<thead>")
+			for i, cell := range padTableRow(p.tableCells(row), columns) {
+				var cellBuff bytes.Buffer
+				p.inlineCell(&cellBuff, cell)
+				p.r.TableHeaderCell(&rowBuff, cellBuff.Bytes(), columnAlignment(aligns, i))
+			}
+			p.r.TableRow(&table, rowBuff.Bytes())
+			table.WriteString("</thead>\n")
+		} else if hasTableHeaders && idx == 1 {
+			continue
+		} else {
+			if !tbodySet {
+				table.WriteString("<tbody>")
+				tbodySet = true
+			}
+			if !reTableHeaders.Match(row) {
+				for i, cell := range padTableRow(p.tableCells(row), columns) {
+					var cellBuff bytes.Buffer
+					p.inlineCell(&cellBuff, cell)
+					p.r.TableCell(&rowBuff, cellBuff.Bytes(), columnAlignment(aligns, i))
+				}
+				p.r.TableRow(&table, rowBuff.Bytes())
+			}
+			if tbodySet && idx == len(rows)-1 {
+				table.WriteString("</tbody>\n")
+				tbodySet = false
+			}
+		}
+	}
+
+	output.WriteString("\n<table>\n")
+	if len(caption) > 0 {
+		output.WriteString("<caption>")
+		p.inline(output, caption)
+		output.WriteString("</caption>\n")
+	}
+	output.Write(table.Bytes())
+	output.WriteString("</table>\n")
+}
+
+// splitTableRow returns the cells of a table row, which may leave out its closing |.
+// A | escaped with a backslash is kept in its cell as a |, and so is a | inside a
+// ~code~ or =verbatim= span.
+func splitTableRow(row []byte) [][]byte {
+	var cells [][]byte
+	var cell []byte
+	for i := 1; i < len(row); i++ {
+		switch {
+		case row[i] == '\\' && i+1 < len(row) && row[i+1] == '|':
+			cell = append(cell, '|')
+			i++
+		case (row[i] == '~' || row[i] == '=') && codeSpanEnd(row, i) > 0:
+			end := codeSpanEnd(row, i)
+			cell = append(cell, row[i:end+1]...)
+			i = end
+		case row[i] == '|':
+			cells = append(cells, cell)
+			cell = nil
+		default:
+			cell = append(cell, row[i])
+		}
+	}
+	if len(bytes.TrimSpace(cell)) > 0 {
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// codeSpanEnd returns the index of the marker closing the ~code~ or =verbatim= span
+// that the marker at start of a table row opens, or -1 when it opens none. The
+// markers follow the rules of inline markup, with a | counting as the edge of a cell.
+func codeSpanEnd(row []byte, start int) int {
+	marker := row[start]
+	if start > 1 && row[start-1] != '|' && !isSpace(row[start-1]) && !isPreChar(row[start-1]) {
+		return -1
+	}
+	if start+1 >= len(row) || isSpace(row[start+1]) {
+		return -1
+	}
+	for i := start + 2; i < len(row); i++ {
+		if row[i] != marker || isSpace(row[i-1]) {
+			continue
+		}
+		if i+1 == len(row) || row[i+1] == '|' || isAcceptablePostClosingChar(row[i+1]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// tableAlignments returns the blackfriday alignment of every column of a separator
+// row such as |:--+:-:+--:|, where a colon on the left, the right or both sides of a
+// column aligns it left, right or center
+func tableAlignments(row []byte) []int {
+	row = bytes.Trim(row, "|")
+	var aligns []int
+	for _, column := range bytes.FieldsFunc(row, func(r rune) bool { return r == '|' || r == '+' }) {
+		align := 0
+		if column[0] == ':' {
+			align |= blackfriday.TABLE_ALIGNMENT_LEFT
+		}
+		if len(column) > 1 && column[len(column)-1] == ':' {
+			align |= blackfriday.TABLE_ALIGNMENT_RIGHT
+		}
+		aligns = append(aligns, align)
+	}
+	return aligns
+}
+
+// columnAlignment returns the alignment of column i, or 0 when it has none
+func columnAlignment(aligns []int, i int) int {
+	if i < len(aligns) {
+		return aligns[i]
+	}
+	return 0
+}
+
+// tableCells returns the cells of a table row, leaving out those past
+// Options.MaxTableColumns
+func (p *parser) tableCells(row []byte) [][]byte {
+	cells := splitTableRow(row)
+	if p.opts.MaxTableColumns > 0 && len(cells) > p.opts.MaxTableColumns {
+		cells = cells[:p.opts.MaxTableColumns]
+	}
+	return cells
+}
+
+// padTableRow adds empty cells to the end of a row until it has columns cells
+func padTableRow(cells [][]byte, columns int) [][]byte {
+	for len(cells) < columns {
+		cells = append(cells, nil)
+	}
+	return cells
+}
+
+var reVertEntity = regexp.MustCompile(`\\vert(\{\})?`)
+
+// inlineCell inline processes the content of a table cell with its text HTML escaped
+// and the \vert entity, which stands in for a | that would otherwise split the cell,
+// turned back into a |
+func (p *parser) inlineCell(out *bytes.Buffer, cell []byte) {
+	cell = reVertEntity.ReplaceAll(bytes.Trim(cell, " \t"), []byte("|"))
+
+	p.escapeText = true
+	p.inline(out, cell)
+	p.escapeText = false
+}
+
+// ~~ Property Drawers
+var reProperty = regexp.MustCompile(`^\s*:([^:\s]+):\s+(.*?)\s*$`)
+
+func isPropertyDrawer(data []byte) bool {
+	return bytes.Equal(data, []byte(":PROPERTIES:"))
+}
+
+// ~~ Drawers
+// drawerMarker is the marker of a drawer being collected; it cannot clash with the
+// name of a block
+const drawerMarker = ":DRAWER:"
+
+var reDrawer = regexp.MustCompile(`^\s*:([A-Za-z][\w-]*):\s*$`)
+
+// isDrawer reports whether data opens a drawer such as :PROPERTIES: or :LOGBOOK:.
+// :RESULTS: drawers hold the results of source blocks and are rendered as content.
+func isDrawer(data []byte) bool {
+	matches := reDrawer.FindSubmatch(data)
+	return matches != nil && !bytes.EqualFold(matches[1], []byte("END")) && !bytes.EqualFold(matches[1], []byte("RESULTS"))
+}
+
+// generateDrawer renders a drawer as Options.DrawerRender or Options.DrawerMode ask
+func (p *parser) generateDrawer(out *bytes.Buffer, name string, lines []string) {
+	if p.opts.DrawerRender != nil {
+		out.WriteString(p.opts.DrawerRender(name, lines))
+		return
+	}
+	if p.opts.DrawerMode != ShowDrawers {
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	out.WriteString("<pre class=\"drawer " + html.EscapeString(string(bytes.ToLower([]byte(name)))) + "\">\n")
+	for _, line := range lines {
+		p.r.NormalText(out, []byte(line))
+		out.WriteByte('\n')
+	}
+	out.WriteString("</pre>\n")
+}
+
+// generateExample writes the body of an example block as escaped literal text, the
+// same way a fixed-width area of : lines is written
+func (p *parser) generateExample(out *bytes.Buffer, body []byte) {
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	out.WriteString("<pre class=\"example\">\n")
+	p.r.NormalText(out, body)
+	out.WriteString("</pre>\n")
+}
 
-	// Check if the next byte is a priority marker
-	if data[i] == '[' && hasPriority(data[i+1]) {
-		priority = string(data[i+1])
-		i += 4 // for "[c]" + ' '
+// generateVerse writes the lines of a verse block as one paragraph, keeping each line
+// break and the indentation at the start of each line
+func (p *parser) generateVerse(out *bytes.Buffer, body []byte) {
+	var lines [][]byte
+	if len(body) > 0 {
+		lines = bytes.Split(bytes.TrimSuffix(body, []byte("\n")), []byte("\n"))
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	out.WriteString("<p class=\"verse\">\n")
+	for i, line := range lines {
+		text := bytes.TrimLeft(line, " \t")
+		out.Write(bytes.Repeat([]byte("&#xa0;"), len(line)-len(text)))
+		p.inline(out, text)
+		if i < len(lines)-1 {
+			p.r.LineBreak(out)
+		} else {
+			out.WriteByte('\n')
+		}
+	}
+	out.WriteString("</p>\n")
+}
+
+// ~~ Dynamic Blocks
+var reBlock = regexp.MustCompile(`(?i)^\s*#\+(BEGIN|END)_(\w+)\s*([0-9A-Za-z_\-]*)?`)
+
+// findBlock returns the submatches of reBlock for a #+BEGIN_ or #+END_ line, with
+// BEGIN or END and the name of the block upper cased since they are matched
+// whatever their case
+func findBlock(data []byte) [][]byte {
+	matches := reBlock.FindSubmatch(data)
+	if matches == nil {
+		return nil
+	}
+	matches[1] = bytes.ToUpper(matches[1])
+	matches[2] = bytes.ToUpper(matches[2])
+	return matches
+}
+
+// blockHeader returns what follows the name of the block on a #+BEGIN_ line, such
+// as the language and header arguments of a source block
+func blockHeader(data []byte) string {
+	loc := reBlock.FindSubmatchIndex(data)
+	if loc == nil {
+		return ""
+	}
+	return string(data[loc[5]:])
+}
+
+func isBlock(data []byte) bool {
+	return reBlock.Match(data)
+}
+
+// isVerbatimBlock reports whether the body of the block name is kept as it is, so
+// the #+BEGIN_ and #+END_ lines inside it are part of its text
+func isVerbatimBlock(name string) bool {
+	return name == "SRC" || name == "EXAMPLE" || name == "EXPORT" || name == "HTML"
+}
+
+// blockTracker follows the blocks of content read line by line the way rendering
+// does: a #+END_ line inside the body of a verbatim block only closes a block that
+// was opened in that body, and quote blocks nest in quote blocks.
+type blockTracker struct {
+	// marker is the name of the open block, or empty outside of blocks
+	marker string
+	// nested holds the names of the blocks opened inside the open one
+	nested []string
+}
+
+// open reports whether a block is open
+func (b *blockTracker) open() bool {
+	return b.marker != ""
+}
+
+// line reads the next line of the content and reports whether it is a #+BEGIN_ or
+// #+END_ line or inside a block
+func (b *blockTracker) line(data []byte) bool {
+	matches := findBlock(data)
+	if b.marker == "" {
+		if len(matches) > 0 && string(matches[1]) == "BEGIN" {
+			b.marker = string(matches[2])
+		}
+		return len(matches) > 0
+	}
+	if len(matches) == 0 {
+		return true
+	}
+
+	name := string(matches[2])
+	verbatim := isVerbatimBlock(b.marker)
+	switch {
+	case string(matches[1]) == "BEGIN":
+		if verbatim || b.marker == "QUOTE" && name == "QUOTE" {
+			b.nested = append(b.nested, name)
+		}
+	case len(b.nested) > 0 && b.nested[len(b.nested)-1] == name && (verbatim && name != b.marker || name == "QUOTE"):
+		b.nested = b.nested[:len(b.nested)-1]
+	case name == b.marker:
+		b.marker = ""
+		b.nested = nil
 	}
+	return true
+}
 
-	tags, tagsFound := findTags(data, i)
-
-	headlineID := sanitized_anchor_name.Create(string(data[i:]))
+// ~~ Footnotes
+var reFootnoteDef = regexp.MustCompile(`^\[fn:([\w]+)\] +(.+)`)
 
-	generate := func() bool {
-		dataEnd := len(data)
-		if tagsFound > 0 {
-			dataEnd = tagsFound
-		}
+func isFootnoteDef(data []byte) bool {
+	return reFootnoteDef.Match(data)
+}
 
-		headline := bytes.TrimRight(data[i:dataEnd], " \t")
+var reFootnoteRef = regexp.MustCompile(`\[fn:([\w]+)\]`)
 
-		if status != "" {
-			out.WriteString("<span class=\"todo " + status + "\">" + status + "</span>")
-			out.WriteByte(' ')
+// addFootnote records a reference to the footnote id and returns the number it is
+// shown with. A footnote referenced again keeps the number it was first given.
+func (p *parser) addFootnote(id string) int {
+	if p.footnoteNumbers == nil {
+		for i := range p.notes {
+			if p.notes[i].id == id {
+				return p.notes[i].number
+			}
 		}
+		p.notes = append(p.notes, footnotes{id: id, number: len(p.notes) + 1})
+		return len(p.notes)
+	}
 
-		if priority != "" {
-			out.WriteString("<span class=\"priority " + priority + "\">[" + priority + "]</span>")
-			out.WriteByte(' ')
+	number, ok := p.footnoteNumbers[id]
+	if !ok {
+		number = len(p.footnoteNumbers) + 1
+		p.footnoteNumbers[id] = number
+	}
+	for i := range p.notes {
+		if p.notes[i].id == id {
+			return number
 		}
+	}
+	p.notes = append(p.notes, footnotes{id: id, number: number})
+	return number
+}
 
-		p.inline(out, headline)
-
-		if tagsFound > 0 {
-			for _, tag := range tags {
-				out.WriteByte(' ')
-				out.WriteString("<span class=\"tags " + tag + "\">" + tag + "</span>")
-				out.WriteByte(' ')
+// generateInlineFootnote renders an anonymous [fn::text] footnote, data starting
+// after its [, as a reference to a numbered footnote holding the inline processed
+// text. The text may hold brackets of its own, such as those of a [[link]].
+func (p *parser) generateInlineFootnote(out *bytes.Buffer, data []byte) int {
+	depth := 1
+	end := -1
+	for i := len("fn::"); i < len(data) && end < 0; i++ {
+		switch data[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth == 0 {
+				end = i
 			}
 		}
-		return true
+	}
+	if end < 0 {
+		return 0
+	}
+	text := bytes.TrimSpace(data[len("fn::"):end])
+	if len(text) == 0 {
+		return 0
 	}
 
-	p.r.Header(out, generate, level, headlineID)
+	p.inlineFootnotes++
+	id := fmt.Sprintf("inline-%d", p.inlineFootnotes)
+	var def bytes.Buffer
+	p.inline(&def, text)
+	number := p.addFootnote(id)
+	for i := range p.notes {
+		if p.notes[i].id == id {
+			p.notes[i].def = def.String()
+		}
+	}
+	p.footnoteRef(out, []byte(id), number)
+	return end + 2
 }
 
-func hasStatus(data []byte) bool {
-	return bytes.Contains(data, []byte("TODO")) || bytes.Contains(data, []byte("DONE"))
-}
+// footnoteRef renders a reference to the footnote id. The renderer gives every
+// reference to a footnote the same id, so the second and later references get .2,
+// .3 and so on added to theirs.
+func (p *parser) footnoteRef(out *bytes.Buffer, id []byte, number int) {
+	if p.footnoteRefs == nil {
+		p.footnoteRefs = make(map[string]int)
+	}
+	p.footnoteRefs[string(id)]++
+	count := p.footnoteRefs[string(id)]
+	if count == 1 {
+		p.r.FootnoteRef(out, id, number)
+		return
+	}
 
-func hasPriority(char byte) bool {
-	return (charMatches(char, 'A') || charMatches(char, 'B') || charMatches(char, 'C'))
+	var ref bytes.Buffer
+	p.r.FootnoteRef(&ref, id, number)
+	tag := ref.Bytes()
+	if _, end := idAttribute(tag); end >= 0 {
+		out.Write(tag[:end])
+		out.WriteString("." + strconv.Itoa(count))
+		tag = tag[end:]
+	}
+	out.Write(tag)
 }
 
-func findTags(data []byte, start int) ([]string, int) {
-	tags := []string{}
-	tagOpener := 0
-	tagMarker := tagOpener
-	for tIdx := start; tIdx < len(data); tIdx++ {
-		if tagMarker > 0 && data[tIdx] == ':' {
-			tags = append(tags, string(data[tagMarker+1:tIdx]))
-			tagMarker = tIdx
+// collectFootnotes finds the footnotes that are defined and, when footnotes are
+// numbered in the order of their definitions, numbers the ones that are referenced
+func (p *parser) collectFootnotes(input []byte) {
+	var defined, referenced []string
+	var blocks blockTracker
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if blocks.line(data) || isExampleLine(data) || isComment(data) {
+			continue
+		}
+
+		if matches := reFootnoteDef.FindSubmatch(data); matches != nil {
+			if id := string(matches[1]); !containsString(defined, id) {
+				defined = append(defined, id)
+			}
+			data = matches[2]
 		}
-		if data[tIdx] == ':' && tagOpener == 0 && data[tIdx-1] == ' ' {
-			tagMarker = tIdx
-			tagOpener = tIdx
+		for _, matches := range reFootnoteRef.FindAllSubmatch(data, -1) {
+			if id := string(matches[1]); !containsString(referenced, id) {
+				referenced = append(referenced, id)
+			}
 		}
 	}
-	return tags, tagOpener
-}
 
-// Greater Elements
-// ~~ Definition Lists
-var reDefinitionList = regexp.MustCompile(`^\s*-\s+(.+?)\s+::\s+(.*)`)
+	p.footnoteDefs = make(map[string]bool)
+	for _, id := range defined {
+		p.footnoteDefs[id] = true
+	}
+	if p.opts.FootnoteOrder != FootnotesByDefinition {
+		return
+	}
 
-func isDefinitionList(data []byte) bool {
-	return reDefinitionList.Match(data)
+	p.footnoteNumbers = make(map[string]int)
+	for _, id := range defined {
+		if containsString(referenced, id) {
+			p.footnoteNumbers[id] = len(p.footnoteNumbers) + 1
+		}
+	}
 }
 
-// ~~ Example lines
-var reExampleLine = regexp.MustCompile(`^\s*:\s(\s*.*)|^\s*:$`)
-
-func isExampleLine(data []byte) bool {
-	return reExampleLine.Match(data)
+// Elements
+// ~~ Keywords
+func IsKeyword(data []byte) bool {
+	return len(data) > 2 && charMatches(data[0], '#') && charMatches(data[1], '+') && !charMatches(data[2], ' ')
 }
 
-// ~~ Ordered Lists
-var reOrderedList = regexp.MustCompile(`^(\s*)\d+\.\s+\[?@?(\d*)\]?(.+)`)
+// ~~ Results
+var reResults = regexp.MustCompile(`(?i)^\s*#\+RESULTS(\[[^\]]*\])?:`)
 
-func isOrderedList(data []byte) bool {
-	return reOrderedList.Match(data)
+func isResults(data []byte) bool {
+	return reResults.Match(data)
 }
 
-// ~~ Unordered Lists
-var reUnorderedList = regexp.MustCompile(`^(\s*)[-\+]\s+(.+)`)
-
-func isUnorderedList(data []byte) bool {
-	return reUnorderedList.Match(data)
+// resultsReader reads over the lines of the results of a source block. The results
+// are either a block, a :RESULTS: drawer or the lines up to an empty line. The results
+// are skipped, except when raw is set: then the lines of the last kind are kept in
+// lines, and the other kinds are left to be rendered as usual.
+type resultsReader struct {
+	active  bool
+	started bool
+	// end is the line closing a block or drawer, or empty when an empty line ends the results
+	end   string
+	raw   bool
+	lines [][]byte
 }
 
-// ~~ Tables
-var reTableHeaders = regexp.MustCompile(`^[|+-]*$`)
-
-func isTable(data []byte) bool {
-	return charMatches(data[0], '|')
+func (s *resultsReader) start(raw bool) {
+	*s = resultsReader{active: true, raw: raw}
 }
 
-func (p *parser) generateTable(output *bytes.Buffer, data []byte) {
-	var table bytes.Buffer
-	rows := bytes.Split(bytes.Trim(data, "\n"), []byte("\n"))
-	hasTableHeaders := len(rows) > 1
-	if len(rows) > 1 {
-		hasTableHeaders = reTableHeaders.Match(rows[1])
+// read reports whether data belongs to the results being read
+func (s *resultsReader) read(data []byte) bool {
+	if !s.active {
+		return false
 	}
-	tbodySet := false
 
-	for idx, row := range rows {
-		var rowBuff bytes.Buffer
-		if hasTableHeaders && idx == 0 {
-			table.WriteString("<thead>")
-			for _, cell := range bytes.Split(row[1:len(row)-1], []byte("|")) {
-				p.r.TableHeaderCell(&rowBuff, bytes.Trim(cell, " \t"), 0)
-			}
-			p.r.TableRow(&table, rowBuff.Bytes())
-			table.WriteString("</thead>\n")
-		} else if hasTableHeaders && idx == 1 {
-			continue
-		} else {
-			if !tbodySet {
-				table.WriteString("<tbody>")
-				tbodySet = true
-			}
-			if !reTableHeaders.Match(row) {
-				for _, cell := range bytes.Split(row[1:len(row)-1], []byte("|")) {
-					var cellBuff bytes.Buffer
-					p.inline(&cellBuff, bytes.Trim(cell, " \t"))
-					p.r.TableCell(&rowBuff, cellBuff.Bytes(), 0)
-				}
-				p.r.TableRow(&table, rowBuff.Bytes())
-			}
-			if tbodySet && idx == len(rows)-1 {
-				table.WriteString("</tbody>\n")
-				tbodySet = false
+	trimmed := bytes.TrimSpace(data)
+	if !s.started {
+		s.started = true
+		end := ""
+		if matches := findBlock(trimmed); len(matches) > 0 && string(matches[1]) == "BEGIN" {
+			end = "#+END_" + string(matches[2])
+		} else if bytes.EqualFold(trimmed, []byte(":RESULTS:")) {
+			end = ":END:"
+		}
+		if end != "" {
+			if s.raw {
+				s.active = false
+				return false
 			}
+			s.end = end
+			return true
 		}
 	}
 
-	output.WriteString("\n<table>\n")
-	output.Write(table.Bytes())
-	output.WriteString("</table>\n")
+	if s.end != "" {
+		if bytes.EqualFold(trimmed, []byte(s.end)) {
+			s.active = false
+		}
+		return true
+	}
+	if isEmpty(data) {
+		s.active = false
+		return false
+	}
+	if s.raw {
+		s.lines = append(s.lines, append([]byte(nil), data...))
+	}
+	return true
 }
 
-// ~~ Property Drawers
-
-func isPropertyDrawer(data []byte) bool {
-	return bytes.Equal(data, []byte(":PROPERTIES:"))
+// shebangLangs maps interpreters to the languages of source blocks
+var shebangLangs = map[string]string{
+	"bash":    "bash",
+	"fish":    "fish",
+	"lua":     "lua",
+	"node":    "js",
+	"nodejs":  "js",
+	"perl":    "perl",
+	"php":     "php",
+	"python":  "python",
+	"Rscript": "R",
+	"ruby":    "ruby",
+	"sh":      "sh",
+	"zsh":     "zsh",
 }
 
-// ~~ Dynamic Blocks
-var reBlock = regexp.MustCompile(`^\s*#\+(BEGIN|END)_(\w+)\s*([0-9A-Za-z_\-]*)?`)
+var reShebang = regexp.MustCompile(`^#!\s*(?:\S*/)?(?:env\s+(?:-\S+\s+)*)?([A-Za-z]+)[\d.]*(?:\s|$)`)
 
-func isBlock(data []byte) bool {
-	return reBlock.Match(data)
+// shebangLang returns the language of the interpreter on the #! line starting code,
+// or an empty string when there is none or it is not known
+func shebangLang(code []byte) string {
+	matches := reShebang.FindSubmatch(code)
+	if matches == nil {
+		return ""
+	}
+	return shebangLangs[string(matches[1])]
 }
 
-// ~~ Footnotes
-var reFootnoteDef = regexp.MustCompile(`^\[fn:([\w]+)\] +(.+)`)
+var reFileLink = regexp.MustCompile(`\[\[file:([^\]/~][^\]]*)\]`)
 
-func isFootnoteDef(data []byte) bool {
-	return reFootnoteDef.Match(data)
+// reBareLink matches a link whose path has no link type, leaving out links to
+// headlines, ids and coderefs
+var reBareLink = regexp.MustCompile(`\[\[([^\]:*#(][^\]:]*)\]`)
+
+// resolveResultLinks prepares the links in a line of the results of block. With
+// :results file, links without a type are file links, so images among them are
+// rendered as images, and the relative paths of file links are resolved against :dir.
+func resolveResultLinks(data []byte, block *SrcBlock) []byte {
+	if block.hasResults("file") {
+		data = reBareLink.ReplaceAll(data, []byte("[[file:$1]"))
+	}
+	if dir := block.Dir(); dir != "" {
+		data = resolveFileLinks(data, dir)
+	}
+	return data
 }
 
-// Elements
-// ~~ Keywords
-func IsKeyword(data []byte) bool {
-	return len(data) > 2 && charMatches(data[0], '#') && charMatches(data[1], '+') && !charMatches(data[2], ' ')
+// resolveFileLinks joins dir onto the relative paths of the [[file:]] links in data
+func resolveFileLinks(data []byte, dir string) []byte {
+	return reFileLink.ReplaceAllFunc(data, func(link []byte) []byte {
+		rel := reFileLink.FindSubmatch(link)[1]
+		return []byte("[[file:" + path.Join(dir, string(rel)) + "]")
+	})
+}
+
+// generateRawResults renders the lines of :results output as escaped text in a <pre>,
+// without the ": " that starts fixed width lines
+func (p *parser) generateRawResults(out *bytes.Buffer, lines [][]byte) {
+	out.WriteString("<pre class=\"example\">\n")
+	for _, data := range lines {
+		if matches := reExampleLine.FindSubmatch(data); matches != nil {
+			data = matches[1]
+		}
+		p.r.NormalText(out, data)
+		out.WriteByte('\n')
+	}
+	out.WriteString("</pre>\n")
 }
 
 // ~~ Comments
@@ -559,25 +2157,83 @@ func (p *parser) generateComment(out *bytes.Buffer, data []byte) {
 }
 
 // ~~ Horizontal Rules
-var reHorizontalRule = regexp.MustCompile(`^\s*?-----\s?$`)
+var reHorizontalRule = regexp.MustCompile(`^\s*-{5,}\s*$`)
 
 func isHorizontalRule(data []byte) bool {
 	return reHorizontalRule.Match(data)
 }
 
+// generateHorizontalRule renders an <hr>, with Options.HorizontalRuleClass as its class when set
+func (p *parser) generateHorizontalRule(out *bytes.Buffer) {
+	if p.opts.HorizontalRuleClass == "" {
+		p.r.HRule(out)
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	out.WriteString("<hr class=\"" + html.EscapeString(p.opts.HorizontalRuleClass) + "\"")
+	if p.r.GetFlags()&blackfriday.HTML_USE_XHTML != 0 {
+		out.WriteString(" />\n")
+	} else {
+		out.WriteString(">\n")
+	}
+}
+
 // ~~ Paragraphs
 func (p *parser) generateParagraph(out *bytes.Buffer, data []byte) {
 	generate := func() bool {
-		p.inline(out, bytes.Trim(data, " "))
+		p.inline(out, trimLineEnds(bytes.Trim(data, " ")))
 		return true
 	}
-	p.r.Paragraph(out, generate)
+	switch {
+	case p.opts.UnwrapParagraphs:
+		if out == p.paragraphOut && out.Len() == p.paragraphEnd {
+			out.WriteString(p.opts.ParagraphBreak)
+		} else if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		generate()
+		out.WriteByte('\n')
+		p.paragraphOut, p.paragraphEnd = out, out.Len()
+	case p.opts.ParagraphTag != "":
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString("<" + p.opts.ParagraphTag + ">")
+		generate()
+		out.WriteString("</" + p.opts.ParagraphTag + ">\n")
+	default:
+		p.r.Paragraph(out, generate)
+	}
+}
+
+var reLineEnd = regexp.MustCompile(`[ \t]+\n`)
+
+// trimLineEnds drops the spaces and tabs at the end of every line of text so
+// they do not show up in the output
+func trimLineEnds(text []byte) []byte {
+	return bytes.TrimRight(reLineEnd.ReplaceAll(text, []byte("\n")), " \t")
+}
+
+// paragraphTags returns the lines that open and close a paragraph of a quote or
+// other block, following Options.ParagraphTag and Options.UnwrapParagraphs
+func (p *parser) paragraphTags() (open, close string) {
+	switch {
+	case p.opts.UnwrapParagraphs:
+		return "", ""
+	case p.opts.ParagraphTag != "":
+		return "<" + p.opts.ParagraphTag + ">\n", "</" + p.opts.ParagraphTag + ">\n"
+	}
+	return "<p>\n", "</p>\n"
 }
 
 func (p *parser) generateList(output *bytes.Buffer, data []byte, listType string) {
+	// the items have already been inline processed
 	generateList := func() bool {
 		output.WriteByte('\n')
-		p.inline(output, bytes.Trim(data, " "))
+		output.Write(bytes.Trim(data, " "))
 		return true
 	}
 	switch listType {
@@ -600,7 +2256,11 @@ func (p *parser) inline(out *bytes.Buffer, data []byte) {
 			end++
 		}
 
-		p.r.Entity(out, data[i:end])
+		if p.escapeText {
+			p.r.NormalText(out, data[i:end])
+		} else {
+			p.r.Entity(out, data[i:end])
+		}
 
 		if end >= len(data) {
 			break
@@ -625,39 +2285,83 @@ func isAcceptablePreOpeningChar(dataIn, data []byte, offset int) bool {
 	}
 
 	char := dataIn[offset-1]
-	return charMatches(char, ' ') || isPreChar(char)
+	return isSpace(char) || isPreChar(char)
 }
 
 func isPreChar(char byte) bool {
-	return charMatches(char, '>') || charMatches(char, '(') || charMatches(char, '{') || charMatches(char, '[')
+	return charMatches(char, '>') || charMatches(char, '(') || charMatches(char, '{') || charMatches(char, '[') || charMatches(char, '-') || charMatches(char, '\'') || charMatches(char, '"')
 }
 
 func isAcceptablePostClosingChar(char byte) bool {
-	return charMatches(char, ' ') || isTerminatingChar(char)
+	return isSpace(char) || isTerminatingChar(char)
+}
+
+// isAcceptableClosingMarker reports whether the marker at data[i] can close a span:
+// it follows a non-whitespace character and ends data or comes before whitespace or
+// a terminating character
+func isAcceptableClosingMarker(data []byte, i int) bool {
+	return i > 0 && !isSpace(data[i-1]) && (len(data) == i+1 || isAcceptablePostClosingChar(data[i+1]))
 }
 
 func isTerminatingChar(char byte) bool {
-	return charMatches(char, '.') || charMatches(char, ',') || charMatches(char, '?') || charMatches(char, '!') || charMatches(char, ')') || charMatches(char, '}') || charMatches(char, ']')
+	return charMatches(char, '.') || charMatches(char, ',') || charMatches(char, '?') || charMatches(char, '!') || charMatches(char, ')') || charMatches(char, '}') || charMatches(char, ']') ||
+		charMatches(char, ';') || charMatches(char, ':') || charMatches(char, '-') || charMatches(char, '\'') || charMatches(char, '"') || charMatches(char, '\\') || charMatches(char, '[')
 }
 
-func findLastCharInInline(data []byte, char byte) int {
-	timesFound := 0
-	last := 0
-	// Start from character after the inline indicator
-	for i := 1; i < len(data); i++ {
-		if timesFound == 1 {
+// findLastCharInInline returns the index of the marker closing the inline starting at
+// data[0], or 0 when there is none. The search stops at a blank line, since markup
+// never spans paragraphs, and a maxSpan above 0 limits how many bytes it searches.
+func findLastCharInInline(data []byte, char byte, intraWord bool, maxSpan int) int {
+	end := len(data)
+	if maxSpan > 0 && maxSpan+2 < end {
+		end = maxSpan + 2
+	}
+	for i := 1; i < end; i++ {
+		if data[i-1] == '\n' && isEmpty(data[i:]) {
+			end = i
 			break
 		}
-		if data[i] == char {
-			if len(data) == i+1 || (len(data) > i+1 && isAcceptablePostClosingChar(data[i+1])) {
+	}
+
+	// A marker also closes a span when another span opens right after it, as in
+	// *bold*/italic/. Walking back from the end keeps the nearest closing marker of
+	// each kind, so whether a span opens is known without searching again.
+	var nearest [256]int
+	last := 0
+	opensNext := false
+	for i := end - 1; i >= 1; i-- {
+		c := data[i]
+		if c != char && !isMarkupChar(c) {
+			opensNext = false
+			continue
+		}
+		opens := isMarkupChar(c) && i+2 < len(data) && !isSpace(data[i+1]) && nearest[c] > i+1 &&
+			(maxSpan <= 0 || nearest[c]-i <= maxSpan+1)
+		// the closing marker must follow a non-whitespace character
+		if isAcceptableClosingMarker(data, i) || !isSpace(data[i-1]) && (intraWord || opensNext) {
+			nearest[c] = i
+			if c == char {
 				last = i
-				timesFound += 1
 			}
 		}
+		opensNext = opens
 	}
 	return last
 }
 
+func isMarkupChar(char byte) bool {
+	return charMatches(char, '=') || charMatches(char, '~') || charMatches(char, '/') || charMatches(char, '_') || charMatches(char, '*') || charMatches(char, '+')
+}
+
+// opensSpan reports whether data starts with a complete span of markup, so that a
+// closing marker right before it ends a span of its own, as in *bold*/italic/
+func opensSpan(data []byte, intraWord bool, maxSpan int) bool {
+	if len(data) < 3 || !isMarkupChar(data[0]) || isSpace(data[1]) {
+		return false
+	}
+	return findLastCharInInline(data, data[0], intraWord, maxSpan) > 1
+}
+
 func generator(p *parser, out *bytes.Buffer, dataIn []byte, offset int, char byte, doInline bool, renderer func(*bytes.Buffer, []byte)) int {
 	data := dataIn[offset:]
 	c := byte(char)
@@ -667,11 +2371,12 @@ func generator(p *parser, out *bytes.Buffer, dataIn []byte, offset int, char byt
 		return 0
 	}
 
-	lastCharInside := findLastCharInInline(data, c)
+	intraWord := p.opts.IntraWordEmphasis
+	lastCharInside := findLastCharInInline(data, c, intraWord, p.opts.MaxInlineSpan)
 
 	// Org mode spec says a non-whitespace character must immediately follow.
 	// if the current char is the marker, then there's no text between, not a candidate
-	if isSpace(data[i]) || lastCharInside == i || !isAcceptablePreOpeningChar(dataIn, data, offset) {
+	if isSpace(data[i]) || lastCharInside == i || !intraWord && !isAcceptablePreOpeningChar(dataIn, data, offset) {
 		return 0
 	}
 
@@ -684,6 +2389,10 @@ func generator(p *parser, out *bytes.Buffer, dataIn []byte, offset int, char byt
 			renderer(out, data[start:lastCharInside])
 		}
 		next := lastCharInside + 1
+		if opensSpan(data[next:], intraWord, p.opts.MaxInlineSpan) {
+			// the adjacent span is rendered on its own since its opener follows a marker
+			next += p.inlineCallback[data[next]](p, out, data[next:], 0)
+		}
 		return next
 	}
 
@@ -705,12 +2414,26 @@ func generateEmphasis(p *parser, out *bytes.Buffer, data []byte, offset int) int
 
 func generateUnderline(p *parser, out *bytes.Buffer, data []byte, offset int) int {
 	underline := func(out *bytes.Buffer, text []byte) {
-		out.WriteString("<span style=\"text-decoration: underline;\">")
-		out.Write(text)
-		out.WriteString("</span>")
+		switch p.opts.Underline {
+		case UnderlineU:
+			out.WriteString("<u>")
+			out.Write(text)
+			out.WriteString("</u>")
+		case UnderlineIns:
+			out.WriteString("<ins>")
+			out.Write(text)
+			out.WriteString("</ins>")
+		default:
+			out.WriteString("<span class=\"underline\">")
+			out.Write(text)
+			out.WriteString("</span>")
+		}
 	}
 
-	return generator(p, out, data, offset, '_', true, underline)
+	if consumed := generator(p, out, data, offset, '_', true, underline); consumed > 0 {
+		return consumed
+	}
+	return p.generateScript(out, data, offset, "sub")
 }
 
 func generateBold(p *parser, out *bytes.Buffer, data []byte, offset int) int {
@@ -721,6 +2444,94 @@ func generateStrikethrough(p *parser, out *bytes.Buffer, data []byte, offset int
 	return generator(p, out, data, offset, '+', true, p.r.StrikeThrough)
 }
 
+// ~~ Subscripts and Superscripts
+var reOptionsLine = regexp.MustCompile(`(?i)^#\+OPTIONS:(.*)`)
+
+// collectScriptOption reads the ^: setting of the #+OPTIONS: lines of the content.
+// Scripts are only rendered when it is t or {}, unless Options.Scripts says otherwise.
+func (p *parser) collectScriptOption(input []byte) {
+	p.scripts = p.opts.Scripts
+	if p.scripts != ScriptsByOptions {
+		return
+	}
+
+	p.scripts = ScriptsOff
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		matches := reOptionsLine.FindSubmatch(scanner.Bytes())
+		if matches == nil {
+			continue
+		}
+		for _, option := range bytes.Fields(matches[1]) {
+			switch string(option) {
+			case "^:t":
+				p.scripts = ScriptsOn
+			case "^:{}":
+				p.scripts = ScriptsBraced
+			case "^:nil":
+				p.scripts = ScriptsOff
+			}
+		}
+	}
+}
+
+var (
+	reBracedScript = regexp.MustCompile(`^\{([^{}]*)\}`)
+	reWordScript   = regexp.MustCompile(`^(\*|[+-]?[[:alnum:],.\\]*[[:alnum:]])`)
+	reScriptText   = regexp.MustCompile(`(\S)[\^_](?:\{([^{}]*)\}|(\*|[+-]?[[:alnum:],.\\]*[[:alnum:]]))`)
+)
+
+// scriptBody returns the text of the script whose marker is data[0] and the number of
+// bytes the marker and the script take up
+func (p *parser) scriptBody(data []byte) ([]byte, int) {
+	if matches := reBracedScript.FindSubmatch(data[1:]); matches != nil {
+		return matches[1], len(matches[0]) + 1
+	}
+	if p.scripts == ScriptsOn {
+		if matches := reWordScript.FindSubmatch(data[1:]); matches != nil {
+			return matches[1], len(matches[0]) + 1
+		}
+	}
+	return nil, 0
+}
+
+func generateSuperscript(p *parser, out *bytes.Buffer, data []byte, offset int) int {
+	return p.generateScript(out, data, offset, "sup")
+}
+
+// generateScript renders the ^ or _ script at data[offset] in a <sup> or <sub> tag. A
+// script has to follow a non-whitespace character, as in x^2 or a_{i+1}.
+func (p *parser) generateScript(out *bytes.Buffer, data []byte, offset int, tag string) int {
+	if p.scripts == ScriptsOff || offset == 0 || isSpace(data[offset-1]) {
+		return 0
+	}
+	body, consumed := p.scriptBody(data[offset:])
+	if len(body) == 0 {
+		return 0
+	}
+
+	out.WriteString("<" + tag + ">")
+	p.inline(out, body)
+	out.WriteString("</" + tag + ">")
+	return consumed
+}
+
+// scriptText drops the markers of the scripts in text, so a headline titled x^2 gets
+// the anchor of x2
+func (p *parser) scriptText(text []byte) []byte {
+	if p.scripts == ScriptsOff {
+		return text
+	}
+	return reScriptText.ReplaceAllFunc(text, func(script []byte) []byte {
+		body, consumed := p.scriptBody(script[1:])
+		if consumed == 0 {
+			return script
+		}
+		return append(append(script[:1:1], body...), script[1+consumed:]...)
+	})
+}
+
 // ~~ Images and Links (inc. Footnote)
 var reLinkOrImg = regexp.MustCompile(`\[\[(.+?)\]\[?(.*?)\]?\]`)
 
@@ -728,63 +2539,128 @@ func generateLinkOrImg(p *parser, out *bytes.Buffer, data []byte, offset int) in
 	data = data[offset+1:]
 	start := 1
 	i := start
-	var hyperlink []byte
+	var path, hyperlink, linkText, search []byte
+	isFile := false
+	// an unresolved coderef or headline link, or one with an empty path, is rendered as plain text
+	isUnresolved := false
+	// isInternal is set for links to headlines and coderefs of the content itself
+	isInternal := false
 	isImage := false
 	isFootnote := false
 	closedLink := false
 	hasContent := false
 
-	if bytes.Equal(data[0:3], []byte("fn:")) {
+	if bytes.HasPrefix(data, []byte("fn::")) {
+		return p.generateInlineFootnote(out, data)
+	} else if bytes.HasPrefix(data, []byte("fn:")) {
 		isFootnote = true
-	} else if data[0] != '[' {
+	} else if len(data) == 0 || data[0] != '[' {
 		return 0
 	}
 
-	if bytes.Equal(data[1:6], []byte("file:")) {
-		isImage = true
+	if bytes.HasPrefix(data[1:], []byte("file:")) {
+		isFile = true
 	}
 
-	for i < len(data) {
+	for i < len(data) && (p.opts.MaxInlineSpan <= 0 || i <= p.opts.MaxInlineSpan+1) {
 		currChar := data[i]
 		switch {
 		case charMatches(currChar, ']') && closedLink == false:
-			if isImage {
+			if isFile {
 				hyperlink = data[start+5 : i]
+				if j := bytes.Index(hyperlink, []byte("::")); j >= 0 {
+					hyperlink, search = hyperlink[:j], hyperlink[j+2:]
+				}
+				isImage = isImagePath(hyperlink) && search == nil
 			} else if isFootnote {
 				refid := data[start+2 : i]
-				if bytes.Equal(refid, bytes.Trim(refid, " ")) {
-					p.notes = append(p.notes, footnotes{string(refid), "DEFINITION NOT FOUND"})
-					p.r.FootnoteRef(out, refid, len(p.notes))
+				// a reference to a footnote without a definition is left as it is written
+				if bytes.Equal(refid, bytes.Trim(refid, " ")) && p.footnoteDefs[string(refid)] {
+					p.footnoteRef(out, refid, p.addFootnote(string(refid)))
 					return i + 2
 				} else {
 					return 0
 				}
-			} else if bytes.Equal(data[i-4:i], []byte(".org")) {
+			} else if bytes.HasSuffix(data[start:i], []byte(".org")) {
 				orgStart := start
-				if bytes.Equal(data[orgStart:orgStart+2], []byte("./")) {
+				if bytes.HasPrefix(data[orgStart:i], []byte("./")) {
 					orgStart = orgStart + 1
 				}
 				hyperlink = data[orgStart : i-4]
 			} else {
 				hyperlink = data[start:i]
 			}
+			path = data[start:i]
+			linkText = hyperlink
+			if isFile {
+				linkText = data[start+5 : i]
+			}
+			if bytes.HasPrefix(hyperlink, []byte("*")) && !isFile {
+				anchor, ok := p.resolveHeadlineLink(hyperlink)
+				// headlines rendered without ids have no anchor to link to, so links
+				// to them are left as their text
+				isInternal = !ok || !p.opts.OmitHeadlineIDs
+				isUnresolved = !ok || p.opts.OmitHeadlineIDs
+				linkText = hyperlink[1:]
+				hyperlink = anchor
+			} else if label, ok := coderefLabel(hyperlink); ok && !isFile {
+				isInternal = true
+				isUnresolved = !p.coderefs[label]
+				if !isUnresolved {
+					linkText = []byte(label)
+				}
+				hyperlink = []byte("#coderef-" + label)
+			} else {
+				hyperlink = append(escapeLinkPath(hyperlink), searchFragment(search)...)
+			}
+			if len(hyperlink) == 0 && !p.opts.AllowEmptyLinks {
+				isUnresolved = true
+			}
 			closedLink = true
 		case charMatches(currChar, '['):
+			// a footnote label or file path holding a [ is not a link
+			if (isFootnote || isFile) && !closedLink {
+				return 0
+			}
 			start = i + 1
 			hasContent = true
 		case charMatches(currChar, ']') && closedLink == true && hasContent == true && isImage == true:
-			p.r.Image(out, hyperlink, data[start:i], data[start:i])
+			alt := data[start:i]
+			if len(alt) == 0 {
+				alt = linkText
+			}
+			p.r.Image(out, hyperlink, alt, alt)
 			return i + 3
 		case charMatches(currChar, ']') && closedLink == true && hasContent == true:
+			if isUnresolved && isInternal && p.onUnresolvedLink(out, path, data[start:i]) {
+				return i + 3
+			}
 			var tmpBuf bytes.Buffer
 			p.inline(&tmpBuf, data[start:i])
+			if isUnresolved {
+				out.Write(tmpBuf.Bytes())
+				return i + 3
+			}
+			if tmpBuf.Len() == 0 {
+				// an empty description falls back to the path
+				p.r.Link(out, hyperlink, linkText, linkText)
+				return i + 3
+			}
 			p.r.Link(out, hyperlink, tmpBuf.Bytes(), tmpBuf.Bytes())
 			return i + 3
 		case charMatches(currChar, ']') && closedLink == true && hasContent == false && isImage == true:
-			p.r.Image(out, hyperlink, hyperlink, hyperlink)
+			// only the src is escaped; the alt and title keep the path as it is written
+			p.r.Image(out, hyperlink, linkText, linkText)
 			return i + 2
 		case charMatches(currChar, ']') && closedLink == true && hasContent == false:
-			p.r.Link(out, hyperlink, hyperlink, hyperlink)
+			if isUnresolved && isInternal && p.onUnresolvedLink(out, path, nil) {
+				return i + 2
+			}
+			if isUnresolved {
+				p.r.NormalText(out, linkText)
+				return i + 2
+			}
+			p.r.Link(out, hyperlink, linkText, linkText)
 			return i + 2
 		}
 		i++
@@ -793,6 +2669,114 @@ func generateLinkOrImg(p *parser, out *bytes.Buffer, data []byte, offset int) in
 	return 0
 }
 
+// onUnresolvedLink renders a link that points nowhere in the content with
+// Options.OnUnresolvedLink and reports whether it did
+func (p *parser) onUnresolvedLink(out *bytes.Buffer, path, desc []byte) bool {
+	if p.opts.OnUnresolvedLink == nil {
+		return false
+	}
+	html, ok := p.opts.OnUnresolvedLink(&Link{Path: string(path), Description: string(desc)})
+	if ok {
+		out.WriteString(html)
+	}
+	return ok
+}
+
+// resolveHeadlineLink turns the path of a [[*Title]] link into the anchor of the
+// first headline with that title. The headline may not be rendered yet, so the
+// anchor holds a marker that fillHeadlineLinks replaces with the headline's id.
+func (p *parser) resolveHeadlineLink(link []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(link, []byte("*")) {
+		return nil, false
+	}
+	line, found := p.titleLines[string(link[1:])]
+	if !found {
+		return nil, false
+	}
+	return []byte("#\x00headline:" + strconv.Itoa(line) + "\x00"), true
+}
+
+var reHeadlineLinkMarker = regexp.MustCompile("\x00headline:(\\d+)\x00")
+
+// fillHeadlineLinks replaces the markers left by resolveHeadlineLink with the id the
+// renderer wrote for each headline, or with its anchor when it was not rendered
+func (p *parser) fillHeadlineLinks(out []byte) []byte {
+	return reHeadlineLinkMarker.ReplaceAllFunc(out, func(marker []byte) []byte {
+		line, _ := strconv.Atoi(string(reHeadlineLinkMarker.FindSubmatch(marker)[1]))
+		id, ok := p.renderedIDs[line]
+		if !ok {
+			id = p.headlineIDs[line]
+		}
+		return []byte(html.EscapeString(id))
+	})
+}
+
+var reCoderefLink = regexp.MustCompile(`^\(([-\w]+)\)$`)
+
+// coderefLabel returns the label of a [[(label)]] link to a line of a source block
+func coderefLabel(link []byte) (string, bool) {
+	matches := reCoderefLink.FindSubmatch(link)
+	if matches == nil {
+		return "", false
+	}
+	return string(matches[1]), true
+}
+
+var reImagePath = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|svg|webp|bmp|tiff?)$`)
+
+func isImagePath(path []byte) bool {
+	return reImagePath.Match(path)
+}
+
+var reLineSearch = regexp.MustCompile(`^[0-9]+$`)
+
+// searchFragment turns the search option after the :: of a file link into the
+// fragment of its URL: #L42 for a line number, the anchor of the headline for
+// *Heading and the id for #custom-id. Other searches are kept after the :: as written.
+func searchFragment(search []byte) []byte {
+	switch {
+	case search == nil:
+		return nil
+	case reLineSearch.Match(search):
+		return append([]byte("#L"), search...)
+	case bytes.HasPrefix(search, []byte("*")):
+		return []byte("#" + sanitized_anchor_name.Create(string(search[1:])))
+	case bytes.HasPrefix(search, []byte("#")):
+		return escapeLinkPath(search)
+	}
+	return append([]byte("::"), escapeLinkPath(search)...)
+}
+
+// escapeLinkPath percent-encodes the bytes of a link path that may not appear in a URL,
+// leaving existing %XX escapes and the # of a fragment as they are
+func escapeLinkPath(path []byte) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '%' && i+2 < len(path) && isHexDigit(path[i+1]) && isHexDigit(path[i+2]):
+			out.WriteByte(c)
+		case isURLChar(c):
+			out.WriteByte(c)
+		default:
+			fmt.Fprintf(&out, "%%%02X", c)
+		}
+	}
+	return out.Bytes()
+}
+
+func isURLChar(c byte) bool {
+	return isAlphanumeric(c) || bytes.IndexByte([]byte("-._~:/?#[]@!$&'()*+,;="), c) >= 0
+}
+
+func isAlphanumeric(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
 // Helpers
 func skipChar(data []byte, start int, char byte) int {
 	i := start
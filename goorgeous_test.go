@@ -2,7 +2,12 @@ package goorgeous
 
 import (
 	"bytes"
+	"errors"
 	"flag"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/russross/blackfriday"
@@ -17,7 +22,8 @@ func TestIsTable(t *testing.T) {
 	}{
 		{"|some table", true},
 		{"| some table", true},
-		{" | not a table", false},
+		{" | indented table", true},
+		{"\t| indented table", true},
 		{"not a table", false},
 		{"*not a table", false},
 		{"-not a table", false},
@@ -54,6 +60,27 @@ func TestSkipChar(t *testing.T) {
 	}
 }
 
+func TestFindLastCharInInline(t *testing.T) {
+	testCases := []struct {
+		in       string
+		char     byte
+		expected int
+	}{
+		{"*bold* text", '*', 5},
+		{"*multi\nline* text", '*', 11},
+		{"*open\n\nnext* text", '*', 0},
+		{"*open\n  \nnext* text", '*', 0},
+		{"~code\n\nnext~ text", '~', 0},
+	}
+
+	for _, tc := range testCases {
+		last := findLastCharInInline([]byte(tc.in), tc.char, false, 0)
+		if last != tc.expected {
+			t.Errorf("findLastCharInInline(%q, %q) = %d\nwants: %d", tc.in, tc.char, last, tc.expected)
+		}
+	}
+}
+
 func TestIsSpace(t *testing.T) {
 	testCases := []struct {
 		char     byte
@@ -195,6 +222,48 @@ func TestRenderingHeadings(t *testing.T) {
 			"<h6 id=\"a-h6-heading\">a h6 heading</h6>\n",
 		},
 
+		"h1-trailing-whitespace": {
+			"* a h1 heading  \t\n",
+			"<h1 id=\"a-h1-heading\">a h1 heading</h1>\n",
+		},
+		"h1-status-extra-whitespace": {
+			"* TODO   a h1 heading   \n",
+			"<h1 id=\"a-h1-heading\"><span class=\"todo TODO\">TODO</span> a h1 heading</h1>\n",
+		},
+		"h1-tags-after-whitespace": {
+			"* Meeting notes \t :work:urgent:\n",
+			"<h1 id=\"meeting-notes\">Meeting notes <span class=\"tags work\">work</span>  <span class=\"tags urgent\">urgent</span> </h1>\n",
+		},
+		"h1-colons-in-title": {
+			"* Ratio 1 : 2 and a :b: c\n",
+			"<h1 id=\"ratio-1-2-and-a-b-c\">Ratio 1 : 2 and a :b: c</h1>\n",
+		},
+		"h1-priority-cookie": {
+			"* TODO [#A] urgent task\n** [#B] thing\n** TODO [#10] numeric\n",
+			"<h1 id=\"urgent-task\"><span class=\"todo TODO\">TODO</span> <span class=\"priority A\">[#A]</span> urgent task</h1>\n\n<h2 id=\"thing\"><span class=\"priority B\">[#B]</span> thing</h2>\n\n<h2 id=\"numeric\"><span class=\"todo TODO\">TODO</span> <span class=\"priority 10\">[#10]</span> numeric</h2>\n",
+		},
+		"h1-priority-mid-title": {
+			"* thing [#A] later\n",
+			"<h1 id=\"thing-a-later\">thing [#A] later</h1>\n",
+		},
+		"h1-status-prefix-word": {
+			"* TODOist sync\n* Todoist export\n",
+			"<h1 id=\"todoist-sync\">TODOist sync</h1>\n\n<h1 id=\"todoist-export\">Todoist export</h1>\n",
+		},
+
+		"empty-sections": {
+			"* One\n* Two\n** Three\n\n\n* Four\n",
+			"<h1 id=\"one\">One</h1>\n\n<h1 id=\"two\">Two</h1>\n\n<h2 id=\"three\">Three</h2>\n\n<h1 id=\"four\">Four</h1>\n",
+		},
+		"empty-section-with-drawer": {
+			"* One\n:PROPERTIES:\n:ID: x\n:END:\n* Two\n",
+			"<h1 id=\"one\">One</h1>\n\n<h1 id=\"two\">Two</h1>\n",
+		},
+		"empty-section-at-eof": {
+			"* One",
+			"<h1 id=\"one\">One</h1>\n",
+		},
+
 		"h1-link": {
 			"* [[https://github.com/chaseadamsio/goorgeous][a heading]]\n",
 			"<h1 id=\"https-github-com-chaseadamsio-goorgeous-a-heading\"><a href=\"https://github.com/chaseadamsio/goorgeous\" title=\"a heading\">a heading</a></h1>\n",
@@ -216,11 +285,507 @@ func TestRenderingHeadings(t *testing.T) {
 			"*** *a h3* heading\n",
 			"<h3 id=\"a-h3-heading\"><strong>a h3</strong> heading</h3>\n",
 		},
+
+		"paragraph-before-heading": {
+			"some text\n* a h1 heading\nmore text\n",
+			"<p>some text</p>\n\n<h1 id=\"a-h1-heading\">a h1 heading</h1>\n\n<p>more text</p>\n",
+		},
+		"list-before-heading": {
+			"- an item\n* a h1 heading\n",
+			"<ul>\n<li>an item</li>\n</ul>\n\n<h1 id=\"a-h1-heading\">a h1 heading</h1>\n",
+		},
+		"only-asterisk": {
+			"*\n",
+			"<p>*</p>\n",
+		},
 	}
 
 	testOrgCommon(testCases, t)
 }
 
+func TestBaseHeadlineLevel(t *testing.T) {
+	testCases := map[string]testCase{
+		"subtree-at-level-3": {
+			"*** Section\n**** Subsection\n***** Detail\n*** Another section\n",
+			"<h2 id=\"section\">Section</h2>\n\n<h3 id=\"subsection\">Subsection</h3>\n\n<h4 id=\"detail\">Detail</h4>\n\n<h2 id=\"another-section\">Another section</h2>\n",
+		},
+		"shallowest-later": {
+			"**** Deep first\n** Shallow\n",
+			"<h4 id=\"deep-first\">Deep first</h4>\n\n<h2 id=\"shallow\">Shallow</h2>\n",
+		},
+		"clamped": {
+			"* Top\n****** Bottom\n",
+			"<h2 id=\"top\">Top</h2>\n\n<h6 id=\"bottom\">Bottom</h6>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.BaseHeadlineLevel = 2
+	testOrgWithOptions(testCases, opts, t)
+
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	if _, err := OrgWithOptions([]byte("* a\n"), renderer, Options{BaseHeadlineLevel: 7}); err == nil {
+		t.Errorf("OrgWithOptions() with BaseHeadlineLevel 7 should return an error")
+	}
+}
+
+func TestTrimBlockTrailingNewline(t *testing.T) {
+	one := "#+BEGIN_SRC sh\necho a\n\necho b\n\n#+END_SRC\n"
+	two := "#+BEGIN_EXAMPLE\nx\n\n\n#+END_EXAMPLE\n"
+
+	trimmedCases := map[string]testCase{
+		"one-trailing-newline": {
+			one,
+			"<pre><code class=\"language-sh\">echo a\n\necho b\n</code></pre>\n",
+		},
+		"two-trailing-newlines": {
+			two,
+			"<pre class=\"example\">\nx\n\n</pre>\n",
+		},
+	}
+	testOrgWithOptions(trimmedCases, DefaultOptions(), t)
+
+	keptCases := map[string]testCase{
+		"one-trailing-newline": {
+			one,
+			"<pre><code class=\"language-sh\">echo a\n\necho b\n\n</code></pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.TrimBlockTrailingNewline = false
+	testOrgWithOptions(keptCases, opts, t)
+}
+
+func TestFootnoteOrder(t *testing.T) {
+	in := "One[fn:b] two[fn:a]\n\n[fn:a] Note A\n\n[fn:b] Note B\n"
+	ref := func(id, number string) string {
+		return "<sup class=\"footnote-ref\" id=\"fnref:" + id + "\"><a rel=\"footnote\" href=\"#fn:" + id + "\">" + number + "</a></sup>"
+	}
+	// a repeated reference gets an id of its own and links to the same footnote
+	repeatedRef := func(id, suffix, number string) string {
+		return "<sup class=\"footnote-ref\" id=\"fnref:" + id + suffix + "\"><a rel=\"footnote\" href=\"#fn:" + id + "\">" + number + "</a></sup>"
+	}
+
+	referenceCases := map[string]testCase{
+		"reference-order": {
+			in,
+			"<p>One" + ref("b", "1") + " two" + ref("a", "2") + "</p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:b\">Note B</li>\n\n<li id=\"fn:a\">Note A</li>\n</ol>\n</div>\n",
+		},
+		"repeated": {
+			"One[fn:b] two[fn:a] three[fn:b]\n\n[fn:a] Note A\n\n[fn:b] Note B\n",
+			"<p>One" + ref("b", "1") + " two" + ref("a", "2") + " three" + repeatedRef("b", ".2", "1") + "</p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:b\">Note B</li>\n\n<li id=\"fn:a\">Note A</li>\n</ol>\n</div>\n",
+		},
+	}
+	testOrgWithOptions(referenceCases, DefaultOptions(), t)
+
+	definitionCases := map[string]testCase{
+		"definition-order": {
+			in,
+			"<p>One" + ref("b", "2") + " two" + ref("a", "1") + "</p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:a\">Note A</li>\n\n<li id=\"fn:b\">Note B</li>\n</ol>\n</div>\n",
+		},
+		"repeated": {
+			"One[fn:b] two[fn:a] three[fn:b]\n\n[fn:a] Note A\n\n[fn:b] Note B\n",
+			"<p>One" + ref("b", "2") + " two" + ref("a", "1") + " three" + repeatedRef("b", ".2", "2") + "</p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:a\">Note A</li>\n\n<li id=\"fn:b\">Note B</li>\n</ol>\n</div>\n",
+		},
+		"repeated-and-missing": {
+			"One[fn:b] two[fn:z] three[fn:b]\n\n[fn:a] Not referenced\n\n[fn:b] Note B\n",
+			"<p>One" + ref("b", "1") + " two[fn:z] three" + repeatedRef("b", ".2", "1") + "</p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:b\">Note B</li>\n</ol>\n</div>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.FootnoteOrder = FootnotesByDefinition
+	testOrgWithOptions(definitionCases, opts, t)
+}
+
+func TestAllowEmptyLinks(t *testing.T) {
+	testCases := map[string]testCase{
+		"empty-link-path": {
+			"a [[][desc]] b\n",
+			"<p>a <a href=\"\" title=\"desc\">desc</a> b</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.AllowEmptyLinks = true
+	testOrgWithOptions(testCases, opts, t)
+}
+
+func TestMaxInlineSpan(t *testing.T) {
+	testCases := map[string]testCase{
+		"bold-within-span": {
+			"a *bold* b\n",
+			"<p>a <strong>bold</strong> b</p>\n",
+		},
+		"bold-past-span": {
+			"a *toolong* b\n",
+			"<p>a *toolong* b</p>\n",
+		},
+		"code-past-span": {
+			"a ~toolong~ b\n",
+			"<p>a ~toolong~ b</p>\n",
+		},
+		"link-past-span": {
+			"a [[http://example.com]] b\n",
+			"<p>a [[http://example.com]] b</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.MaxInlineSpan = 5
+	testOrgWithOptions(testCases, opts, t)
+
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	if _, err := OrgWithOptions([]byte("a\n"), renderer, Options{MaxInlineSpan: -1}); err == nil {
+		t.Errorf("OrgWithOptions() with a negative MaxInlineSpan should return an error")
+	}
+}
+
+func BenchmarkAdjacentSpans(b *testing.B) {
+	// every marker here may close a span that an adjacent span follows, which once
+	// made each of them search the rest of the line again
+	input := []byte(strings.Repeat("/a*", 4000) + "\n")
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	for i := 0; i < b.N; i++ {
+		if _, err := OrgWithOptions(input, renderer, DefaultOptions()); err != nil {
+			b.Fatalf("OrgWithOptions() returned an error: %v", err)
+		}
+	}
+}
+
+func TestHighlighter(t *testing.T) {
+	testCases := map[string]testCase{
+		"highlighted": {
+			"#+BEGIN_SRC sh\necho a\n#+END_SRC\n",
+			"<pre><code class=\"language-sh\"><span class=\"kw\">echo a\n</span></code></pre>\n",
+		},
+		"unknown-language-escaped": {
+			"#+BEGIN_SRC brainfsck\n<+>\n#+END_SRC\n",
+			"<pre><code class=\"language-brainfsck\">&lt;+&gt;\n</code></pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.Highlighter = func(lang string, code []byte) ([]byte, error) {
+		if lang != "sh" {
+			return nil, errors.New("unknown language " + lang)
+		}
+		return append(append([]byte(`<span class="kw">`), code...), "</span>"...), nil
+	}
+	testOrgWithOptions(testCases, opts, t)
+}
+
+func TestSrcBlockWrapper(t *testing.T) {
+	var langs []string
+	opts := DefaultOptions()
+	opts.SrcBlockWrapper = func(w io.Writer, lang string, body []byte, renderInner func() error) error {
+		langs = append(langs, lang)
+		io.WriteString(w, "<div class=\"code\">")
+		if err := renderInner(); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "<button>Copy</button></div>\n")
+		return err
+	}
+	testOrgWithOptions(map[string]testCase{
+		"wrapped": {
+			"text\n#+BEGIN_SRC go\nx := 1\n#+END_SRC\n",
+			"<p>text</p>\n\n<div class=\"code\">\n<pre><code class=\"language-go\">x := 1\n</code></pre>\n<button>Copy</button></div>\n",
+		},
+	}, opts, t)
+	if len(langs) != 1 || langs[0] != "go" {
+		t.Errorf("SrcBlockWrapper was called for %v\nwants: one go block", langs)
+	}
+
+	opts.SrcBlockWrapper = func(w io.Writer, lang string, body []byte, renderInner func() error) error {
+		io.WriteString(w, "<div>")
+		return errors.New("no wrapper")
+	}
+	in := "#+BEGIN_SRC go\nx := 1\n#+END_SRC\n"
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	out, err := OrgWithOptions([]byte(in), renderer, opts)
+	if expected := "<pre><code class=\"language-go\">x := 1\n</code></pre>\n"; string(out) != expected {
+		t.Errorf("OrgWithOptions() with a failing SrcBlockWrapper = %s\nwants: %s", out, expected)
+	}
+	if expected := "goorgeous: line 1: SrcBlockWrapper: no wrapper"; err == nil || err.Error() != expected {
+		t.Errorf("OrgWithOptions() with a failing SrcBlockWrapper returned error %v\nwants: %s", err, expected)
+	}
+}
+
+func TestInferLangFromShebang(t *testing.T) {
+	testCases := map[string]testCase{
+		"python-shebang": {
+			"#+BEGIN_SRC\n#!/usr/bin/env python3\nprint(1)\n#+END_SRC\n",
+			"<pre><code class=\"language-python\">#!/usr/bin/env python3\nprint(1)\n</code></pre>\n",
+		},
+		"explicit-lang": {
+			"#+BEGIN_SRC ruby\n#!/usr/bin/env python\nputs 1\n#+END_SRC\n",
+			"<pre><code class=\"language-ruby\">#!/usr/bin/env python\nputs 1\n</code></pre>\n",
+		},
+		"unknown-interpreter": {
+			"#+BEGIN_SRC\n#!/opt/bin/custom\nrun\n#+END_SRC\n",
+			"<pre><code>#!/opt/bin/custom\nrun\n</code></pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.InferLangFromShebang = true
+	testOrgWithOptions(testCases, opts, t)
+
+	offCases := map[string]testCase{
+		"python-shebang": {
+			"#+BEGIN_SRC\n#!/usr/bin/env python3\nprint(1)\n#+END_SRC\n",
+			"<pre><code>#!/usr/bin/env python3\nprint(1)\n</code></pre>\n",
+		},
+	}
+	testOrgWithOptions(offCases, DefaultOptions(), t)
+}
+
+func TestTabToSpaces(t *testing.T) {
+	in := "#+BEGIN_SRC go\nfunc main() {\n\tif true {\n\t\treturn\n\t}\n}\n#+END_SRC\n"
+
+	tabCases := map[string]testCase{
+		"tabs": {
+			in,
+			"<pre><code class=\"language-go\">func main() {\n\tif true {\n\t\treturn\n\t}\n}\n</code></pre>\n",
+		},
+	}
+	testOrgWithOptions(tabCases, DefaultOptions(), t)
+
+	spaceCases := map[string]testCase{
+		"tabs": {
+			in,
+			"<pre><code class=\"language-go\">func main() {\n    if true {\n        return\n    }\n}\n</code></pre>\n",
+		},
+		"example": {
+			"#+BEGIN_EXAMPLE\n\tindented\n#+END_EXAMPLE\n",
+			"<pre class=\"example\">\n    indented\n</pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.TabToSpaces = 4
+	testOrgWithOptions(spaceCases, opts, t)
+}
+
+func TestBlockErrors(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected string
+		err      string
+	}{
+		"unterminated": {
+			"text\n#+BEGIN_SRC sh\necho a\n\n* not a headline\n",
+			"<p>text</p>\n\n<pre><code class=\"language-sh\">echo a\n\n* not a headline\n</code></pre>\n",
+			"goorgeous: line 2: #+BEGIN_SRC has no #+END_SRC",
+		},
+		"mismatched-end": {
+			"#+BEGIN_SRC sh\necho a\n#+END_EXAMPLE\n#+END_SRC\nafter\n",
+			"<pre><code class=\"language-sh\">echo a\n#+END_EXAMPLE\n</code></pre>\n\n<p>after</p>\n",
+			"goorgeous: line 3: #+END_EXAMPLE does not close the #+BEGIN_SRC on line 1, expected #+END_SRC",
+		},
+		"drawer-without-end": {
+			"* One\n:PROPERTIES:\n:ID: x\n* Two\n",
+			"<h1 id=\"one\">One</h1>\n\n<h1 id=\"two\">Two</h1>\n",
+			"goorgeous: line 2: drawer :PROPERTIES: has no :END:",
+		},
+	}
+
+	for caseName, tc := range testCases {
+		renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+		out, err := OrgWithOptions([]byte(tc.in), renderer, DefaultOptions())
+		if !bytes.Equal(out, []byte(tc.expected)) {
+			t.Errorf("case %s for OrgWithOptions() from %s = %s\nwants: %s", caseName, tc.in, out, tc.expected)
+		}
+		if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 1 || multiErr.Errors[0].Error() != tc.err {
+			t.Errorf("case %s for OrgWithOptions() from %s returned error %v\nwants: a *MultiError with %s", caseName, tc.in, err, tc.err)
+		}
+
+		opts := DefaultOptions()
+		opts.Strict = true
+		out, err = OrgWithOptions([]byte(tc.in), renderer, opts)
+		if out != nil {
+			t.Errorf("case %s for strict OrgWithOptions() from %s = %s\nwants: no output", caseName, tc.in, out)
+		}
+		if parseErr, ok := err.(*ParseError); !ok || parseErr.Error() != tc.err {
+			t.Errorf("case %s for strict OrgWithOptions() from %s returned error %v\nwants: a *ParseError with %s", caseName, tc.in, err, tc.err)
+		}
+	}
+}
+
+func TestVerbatimOrgBlocks(t *testing.T) {
+	testCases := map[string]testCase{
+		"src-org-headline": {
+			"#+BEGIN_SRC org\n* TODO Heading :tag:\n/not emphasis/ & <b>\n#+END_SRC\n",
+			"<pre><code class=\"language-org\">* TODO Heading :tag:\n/not emphasis/ &amp; &lt;b&gt;\n</code></pre>\n",
+		},
+		"src-org-nested-block": {
+			"#+BEGIN_SRC org\n#+BEGIN_QUOTE\n- item\n#+END_QUOTE\n#+END_SRC\nafter\n",
+			"<pre><code class=\"language-org\">#+BEGIN_QUOTE\n- item\n#+END_QUOTE\n</code></pre>\n\n<p>after</p>\n",
+		},
+		"example-nested-block": {
+			"#+BEGIN_EXAMPLE\n#+BEGIN_VERSE\n| a |\n#+END_VERSE\n#+END_EXAMPLE\n",
+			"<pre class=\"example\">\n#+BEGIN_VERSE\n| a |\n#+END_VERSE\n</pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.Strict = true
+	testOrgWithOptions(testCases, opts, t)
+}
+
+func TestMaxErrors(t *testing.T) {
+	in := strings.Repeat("#+END_QUOTE\n", 10) + "after\n"
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+
+	opts := DefaultOptions()
+	opts.MaxErrors = 3
+	out, err := OrgWithOptions([]byte(in), renderer, opts)
+	if string(out) != "<p>after</p>\n" {
+		t.Errorf("OrgWithOptions() with MaxErrors = %s\nwants: <p>after</p>", out)
+	}
+	if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 3 || !multiErr.Truncated {
+		t.Errorf("OrgWithOptions() with MaxErrors returned error %#v\nwants: a truncated *MultiError with 3 errors", err)
+	}
+
+	opts.MaxErrors = 0
+	_, err = OrgWithOptions([]byte(in), renderer, opts)
+	if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 10 || multiErr.Truncated {
+		t.Errorf("OrgWithOptions() without MaxErrors returned error %#v\nwants: a *MultiError with all 10 errors", err)
+	}
+}
+
+func TestMaxTableColumns(t *testing.T) {
+	in := "| a | b | c |\n|---+---+---|\n| 1 | 2 |\n"
+	expected := "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n"
+	expectedErr := "goorgeous: line 1: table row has 3 columns, more than the 2 of MaxTableColumns"
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+
+	opts := DefaultOptions()
+	opts.MaxTableColumns = 2
+	out, err := OrgWithOptions([]byte(in), renderer, opts)
+	if string(out) != expected {
+		t.Errorf("OrgWithOptions() with MaxTableColumns from %s = %s\nwants: %s", in, out, expected)
+	}
+	if multiErr, ok := err.(*MultiError); !ok || len(multiErr.Errors) != 1 || multiErr.Errors[0].Error() != expectedErr {
+		t.Errorf("OrgWithOptions() with MaxTableColumns from %s returned error %v\nwants: a *MultiError with %s", in, err, expectedErr)
+	}
+
+	opts.Strict = true
+	out, err = OrgWithOptions([]byte(in), renderer, opts)
+	if parseErr, ok := err.(*ParseError); out != nil || !ok || parseErr.Error() != expectedErr {
+		t.Errorf("strict OrgWithOptions() with MaxTableColumns from %s = %s, %v\nwants: no output and a *ParseError with %s", in, out, err, expectedErr)
+	}
+}
+
+func TestMarkdownHeadings(t *testing.T) {
+	in := "#+TITLE: a title\n# a heading\n## a sub heading\n#not a heading\n"
+
+	orgCases := map[string]testCase{
+		"comment": {
+			in,
+			"<!-- a heading -->\n\n<p>## a sub heading\n#not a heading</p>\n",
+		},
+	}
+	testOrgWithOptions(orgCases, DefaultOptions(), t)
+
+	markdownCases := map[string]testCase{
+		"headings": {
+			in,
+			"<h1 id=\"a-heading\">a heading</h1>\n\n<h2 id=\"a-sub-heading\">a sub heading</h2>\n\n<p>#not a heading</p>\n",
+		},
+		"link-to-heading": {
+			"see [[*a heading]]\n\n# a heading\n",
+			"<p>see <a href=\"#a-heading\" title=\"a heading\">a heading</a></p>\n\n<h1 id=\"a-heading\">a heading</h1>\n",
+		},
+		"inside-block": {
+			"#+BEGIN_SRC sh\n# a shell comment\n#+END_SRC\n",
+			"<pre><code class=\"language-sh\"># a shell comment\n</code></pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.MarkdownHeadings = true
+	testOrgWithOptions(markdownCases, opts, t)
+}
+
+func TestShowResults(t *testing.T) {
+	src := "#+BEGIN_SRC sh\necho hello\n#+END_SRC\n\n#+RESULTS:\n: hello\n\nAfter.\n"
+	silent := "#+BEGIN_SRC sh :results output silent\necho hello\n#+END_SRC\n\n#+RESULTS:\n: hello\n\nAfter.\n"
+	code := "<pre><code class=\"language-sh\">echo hello\n</code></pre>\n"
+
+	shownCases := map[string]testCase{
+		"results": {
+			src,
+			code + "<pre class=\"example\">\nhello\n</pre>\n\n<p>After.</p>\n",
+		},
+		"silent-results": {
+			silent,
+			code + "\n<p>After.</p>\n",
+		},
+		"silent-results-block": {
+			"#+BEGIN_SRC sh :results silent\necho hello\n#+END_SRC\n#+RESULTS:\n#+BEGIN_EXAMPLE\nhello\n\nagain\n#+END_EXAMPLE\nAfter.\n",
+			code + "\n<p>After.</p>\n",
+		},
+		"value-results-table": {
+			"#+BEGIN_SRC python :results value table\nreturn [[1, 2]]\n#+END_SRC\n\n#+RESULTS:\n| 1 | 2 |\n",
+			"<pre><code class=\"language-python\">return [[1, 2]]\n</code></pre>\n\n<table>\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"output-results-text": {
+			"#+BEGIN_SRC sh :results output\necho hello\n#+END_SRC\n\n#+RESULTS:\n| not a <table> |\n: hello\n\nAfter.\n",
+			code + "<pre class=\"example\">\n| not a &lt;table&gt; |\nhello\n</pre>\n\n<p>After.</p>\n",
+		},
+		"dir-image-results": {
+			"#+BEGIN_SRC python :dir /tmp\nplot()\n#+END_SRC\n\n#+RESULTS:\n[[file:plot.png]]\n\n[[file:plot.png]]\n",
+			"<pre><code class=\"language-python\">plot()\n</code></pre>\n\n<p><img src=\"/tmp/plot.png\" alt=\"/tmp/plot.png\" title=\"/tmp/plot.png\" /></p>\n\n<p><img src=\"plot.png\" alt=\"plot.png\" title=\"plot.png\" /></p>\n",
+		},
+		"file-image-results": {
+			"#+BEGIN_SRC python :results file :dir out\nplot()\n#+END_SRC\n\n#+RESULTS:\n[[./plot.png]]\n",
+			"<pre><code class=\"language-python\">plot()\n</code></pre>\n\n<p><img src=\"out/plot.png\" alt=\"out/plot.png\" title=\"out/plot.png\" /></p>\n",
+		},
+		"file-link-results": {
+			"#+BEGIN_SRC python :results file\nsave()\n#+END_SRC\n#+RESULTS:\n[[data.csv]]\n",
+			"<pre><code class=\"language-python\">save()\n</code></pre>\n\n<p><a href=\"data.csv\" title=\"data.csv\">data.csv</a></p>\n",
+		},
+		"dir-absolute-results": {
+			"#+BEGIN_SRC python :dir /tmp\nplot()\n#+END_SRC\n#+RESULTS:\n[[file:/srv/plot.png]]\n",
+			"<pre><code class=\"language-python\">plot()\n</code></pre>\n\n<p><img src=\"/srv/plot.png\" alt=\"/srv/plot.png\" title=\"/srv/plot.png\" /></p>\n",
+		},
+	}
+	testOrgWithOptions(shownCases, DefaultOptions(), t)
+
+	hiddenCases := map[string]testCase{
+		"results": {
+			src,
+			code + "\n<p>After.</p>\n",
+		},
+		"results-drawer": {
+			"#+BEGIN_SRC sh\necho hello\n#+END_SRC\n#+RESULTS:\n:RESULTS:\nhello\n:END:\nAfter.\n",
+			code + "\n<p>After.</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.ShowResults = false
+	testOrgWithOptions(hiddenCases, opts, t)
+}
+
+func TestIntraWordEmphasis(t *testing.T) {
+	in := "foo_bar_baz and x*y*z\n"
+
+	orgCases := map[string]testCase{
+		"intra-word": {
+			in,
+			"<p>foo_bar_baz and x*y*z</p>\n",
+		},
+	}
+	testOrgWithOptions(orgCases, DefaultOptions(), t)
+
+	intraWordCases := map[string]testCase{
+		"intra-word": {
+			in,
+			"<p>foo<span class=\"underline\">bar</span>baz and x<strong>y</strong>z</p>\n",
+		},
+		"word-boundaries": {
+			"an /italic/ word\n",
+			"<p>an <em>italic</em> word</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.IntraWordEmphasis = true
+	testOrgWithOptions(intraWordCases, opts, t)
+}
+
 func TestRenderingInline(t *testing.T) {
 	testCases := map[string]testCase{
 		"no-inline": {"this string should have no inline changes.\n",
@@ -300,6 +865,14 @@ func TestRenderingInline(t *testing.T) {
 			"this string*doesn't have bold text.*\n",
 			"<p>this string*doesn't have bold text.*</p>\n",
 		},
+		"bold-across-lines": {
+			"some *bold\ntext* here\n",
+			"<p>some <strong>bold\ntext</strong> here</p>\n",
+		},
+		"bold-not-across-paragraphs": {
+			"an *unmatched star\n\nin the next* paragraph\n",
+			"<p>an *unmatched star</p>\n\n<p>in the next* paragraph</p>\n",
+		},
 		"bold-inside-simple-ol": {
 			"1. this\n2. is\n3. an\n4. ordered\n5. *list*\n",
 			"<ol>\n<li>this</li>\n<li>is</li>\n<li>an</li>\n<li>ordered</li>\n<li><strong>list</strong></li>\n</ol>\n",
@@ -310,11 +883,11 @@ func TestRenderingInline(t *testing.T) {
 		},
 		"underline": {
 			"this is _underlined text_.\n",
-			"<p>this is <span style=\"text-decoration: underline;\">underlined text</span>.</p>\n",
+			"<p>this is <span class=\"underline\">underlined text</span>.</p>\n",
 		},
 		"underline-with-dot-at-front": {
 			"this is _.underlined text_.\n",
-			"<p>this is <span style=\"text-decoration: underline;\">.underlined text</span>.</p>\n",
+			"<p>this is <span class=\"underline\">.underlined text</span>.</p>\n",
 		},
 		"verbatim": {
 			"this is =inline code=.\n",
@@ -360,6 +933,22 @@ func TestRenderingInline(t *testing.T) {
 			"this has ~~code~.\n",
 			"<p>this has <code>~code</code>.</p>\n",
 		},
+		"code-spaced-markers": {
+			"a ~ b ~ c\n",
+			"<p>a ~ b ~ c</p>\n",
+		},
+		"code-space-before-closer": {
+			"~x ~ more~ end\n",
+			"<p><code>x ~ more</code> end</p>\n",
+		},
+		"code-in-parens": {
+			"(~x~)\n",
+			"<p>(<code>x</code>)</p>\n",
+		},
+		"code-verbatim-punctuation": {
+			"~x~; =y=: \"~z~\"\n",
+			"<p><code>x</code>; <code>y</code>: \"<code>z</code>\"</p>\n",
+		},
 		"code-inside-simple-ol": {
 			"1. this\n2. is\n3. an\n4. ordered\n5. ~list~\n",
 			"<ol>\n<li>this</li>\n<li>is</li>\n<li>an</li>\n<li>ordered</li>\n<li><code>list</code></li>\n</ol>\n",
@@ -373,6 +962,109 @@ func TestRenderingInline(t *testing.T) {
 	testOrgCommon(testCases, t)
 }
 
+func TestRenderingCombinedEmphasis(t *testing.T) {
+	testCases := map[string]testCase{
+		"bold-italic": {
+			"*/x/*\n",
+			"<p><strong><em>x</em></strong></p>\n",
+		},
+		"bold-italic-in-text": {
+			"a */bold italic/* b\n",
+			"<p>a <strong><em>bold italic</em></strong> b</p>\n",
+		},
+		"strikethrough-underline": {
+			"+_y_+\n",
+			"<p><del><span class=\"underline\">y</span></del></p>\n",
+		},
+		"verbatim-wins": {
+			"=~z~=\n",
+			"<p><code>~z~</code></p>\n",
+		},
+		"unbalanced-inner": {
+			"*/x*\n",
+			"<p><strong>/x</strong></p>\n",
+		},
+		"unbalanced-crossed": {
+			"/*x/*\n",
+			"<p>/*x/*</p>\n",
+		},
+		"nearest-closer": {
+			"*a /b* c/\n",
+			"<p><strong>a /b</strong> c/</p>\n",
+		},
+		"adjacent-bold": {
+			"*a**b*\n",
+			"<p><strong>a</strong><strong>b</strong></p>\n",
+		},
+		"adjacent-bold-italic": {
+			"*a*/b/\n",
+			"<p><strong>a</strong><em>b</em></p>\n",
+		},
+		"adjacent-in-text": {
+			"x *bold*/italic/=code= y\n",
+			"<p>x <strong>bold</strong><em>italic</em><code>code</code> y</p>\n",
+		},
+		"empty-markers": {
+			"x ** y\n",
+			"<p>x ** y</p>\n",
+		},
+		"triple-nested": {
+			"_*/x/*_\n",
+			"<p><span class=\"underline\"><strong><em>x</em></strong></span></p>\n",
+		},
+	}
+
+	testOrgCommon(testCases, t)
+}
+
+func TestScripts(t *testing.T) {
+	testCases := map[string]testCase{
+		"headline-superscript": {
+			"#+OPTIONS: ^:t\n* Area x^2\nsee [[*Area x^2]]\n",
+			"<h1 id=\"area-x2\">Area x<sup>2</sup></h1>\n\n<p>see <a href=\"#area-x2\" title=\"Area x^2\">Area x^2</a></p>\n",
+		},
+		"table-cell-scripts": {
+			"#+OPTIONS: ^:t\n| a_1 | b^{n+1} |\n",
+			"<table>\n<tbody>\n<tr>\n<td>a<sub>1</sub></td>\n<td>b<sup>n+1</sup></td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"braced-only": {
+			"#+OPTIONS: toc:nil ^:{}\nx^2 and x^{2}\n",
+			"<p>x^2 and x<sup>2</sup></p>\n",
+		},
+		"after-whitespace": {
+			"#+OPTIONS: ^:t\na ^2 and b _2\n",
+			"<p>a ^2 and b _2</p>\n",
+		},
+		"no-options": {
+			"x^2 and a_1\n",
+			"<p>x^2 and a_1</p>\n",
+		},
+		"scripts-off": {
+			"#+OPTIONS: ^:nil\nx^{2}\n",
+			"<p>x^{2}</p>\n",
+		},
+	}
+
+	testOrgCommon(testCases, t)
+
+	opts := DefaultOptions()
+	opts.Scripts = ScriptsOn
+	testOrgWithOptions(map[string]testCase{
+		"option-on": {
+			"H_2O and x^2 and a_{ij}\n",
+			"<p>H<sub>2O</sub> and x<sup>2</sup> and a<sub>ij</sub></p>\n",
+		},
+	}, opts, t)
+
+	opts.Scripts = ScriptsOff
+	testOrgWithOptions(map[string]testCase{
+		"option-off-overrides-content": {
+			"#+OPTIONS: ^:t\nsnake_case_id and x^{2}\n",
+			"<p>snake_case_id and x^{2}</p>\n",
+		},
+	}, opts, t)
+}
+
 func TestRenderingLinksAndImages(t *testing.T) {
 
 	testCases := map[string]testCase{
@@ -391,6 +1083,82 @@ func TestRenderingLinksAndImages(t *testing.T) {
 			"this has [[file:../gopher.gif][a uni-gopher]] as an image.\n",
 			"<p>this has <img src=\"../gopher.gif\" alt=\"a uni-gopher\" title=\"a uni-gopher\" /> as an image.</p>\n",
 		},
+		"image-bare-path-with-space": {
+			"see [[file:my pic.png]] here\n",
+			"<p>see <img src=\"my%20pic.png\" alt=\"my pic.png\" title=\"my pic.png\" /> here</p>\n",
+		},
+		"empty-link": {
+			"a [[]] b\n",
+			"<p>a  b</p>\n",
+		},
+		"bracket-in-footnote-label": {
+			"x [fn:[X]\n",
+			"<p>x [fn:[X]</p>\n",
+		},
+		"bracket-in-file-path": {
+			"x [[file:[X]] y\n",
+			"<p>x [[file:[X]] y</p>\n",
+		},
+		"empty-link-path": {
+			"a [[][desc]] b\n",
+			"<p>a desc b</p>\n",
+		},
+		"empty-link-description": {
+			"a [[https://x.com][]] b\n",
+			"<p>a <a href=\"https://x.com\" title=\"https://x.com\">https://x.com</a> b</p>\n",
+		},
+		"empty-image-description": {
+			"a [[file:a.png][]] b\n",
+			"<p>a <img src=\"a.png\" alt=\"a.png\" title=\"a.png\" /> b</p>\n",
+		},
+		"coderef": {
+			"See [[(jump)]] or [[(jump)][this line]].\n\n#+BEGIN_SRC go -l \"(ref:%s)\"\nx := 1\ngoto end (ref:jump)\n#+END_SRC\n",
+			"<p>See <a href=\"#coderef-jump\" title=\"jump\">jump</a> or <a href=\"#coderef-jump\" title=\"this line\">this line</a>.</p>\n\n<pre><code class=\"language-go\">x := 1\ngoto end <span id=\"coderef-jump\" class=\"coderef\">(jump)</span>\n</code></pre>\n",
+		},
+		"coderef-unresolved": {
+			"See [[(nowhere)]].\n",
+			"<p>See (nowhere).</p>\n",
+		},
+		"anchor-headline": {
+			"see [[*A Heading]] and [[*A Heading][this heading]].\n* A Heading\n",
+			"<p>see <a href=\"#a-heading\" title=\"A Heading\">A Heading</a> and <a href=\"#a-heading\" title=\"this heading\">this heading</a>.</p>\n\n<h1 id=\"a-heading\">A Heading</h1>\n",
+		},
+		"anchor-headline-unresolved": {
+			"see [[*Missing]] and [[*Missing][this heading]].\n",
+			"<p>see Missing and this heading.</p>\n",
+		},
+		"anchor-short-path": {
+			"this has [[a]] as a link.\n",
+			"<p>this has <a href=\"a\" title=\"a\">a</a> as a link.</p>\n",
+		},
+		"anchor-path-with-space": {
+			"this has [[file:my file.org]] as a link.\n",
+			"<p>this has <a href=\"my%20file.org\" title=\"my file.org\">my file.org</a> as a link.</p>\n",
+		},
+		"anchor-path-already-encoded": {
+			"this has [[https://example.com/a%20b.html][an encoded path]] as a link.\n",
+			"<p>this has <a href=\"https://example.com/a%20b.html\" title=\"an encoded path\">an encoded path</a> as a link.</p>\n",
+		},
+		"anchor-path-with-fragment": {
+			"this has [[https://example.com/my page.html#a section][a page]] as a link.\n",
+			"<p>this has <a href=\"https://example.com/my%20page.html#a%20section\" title=\"a page\">a page</a> as a link.</p>\n",
+		},
+		"file-link-line-search": {
+			"see [[file:main.go::42]] for it.\n",
+			"<p>see <a href=\"main.go#L42\" title=\"main.go::42\">main.go::42</a> for it.</p>\n",
+		},
+		"file-link-headline-search": {
+			"see [[file:notes.org::*Some Heading][the notes]] for it.\n",
+			"<p>see <a href=\"notes.org#some-heading\" title=\"the notes\">the notes</a> for it.</p>\n",
+		},
+		"file-link-text-search": {
+			"see [[file:notes.org::find me][the notes]] for it.\n",
+			"<p>see <a href=\"notes.org::find%20me\" title=\"the notes\">the notes</a> for it.</p>\n",
+		},
+		"image-path-with-space": {
+			"this has [[file:../a gopher.gif][a gopher]] as an image.\n",
+			"<p>this has <img src=\"../a%20gopher.gif\" alt=\"a gopher\" title=\"a gopher\" /> as an image.</p>\n",
+		},
 		"link-inside-simple-ol": {
 			"1. this\n2. is\n3. an\n4. ordered\n5. list with [[https://github.com/chaseadamsio/goorgeous][goorgeous by chaseadamsio]] as a link\n",
 			"<ol>\n<li>this</li>\n<li>is</li>\n<li>an</li>\n<li>ordered</li>\n<li>list with <a href=\"https://github.com/chaseadamsio/goorgeous\" title=\"goorgeous by chaseadamsio\">goorgeous by chaseadamsio</a> as a link</li>\n</ol>\n",
@@ -404,16 +1172,84 @@ func TestRenderingLinksAndImages(t *testing.T) {
 	testOrgCommon(testCases, t)
 }
 
+func TestOnUnresolvedLink(t *testing.T) {
+	testCases := map[string]testCase{
+		"headline": {
+			"see [[*Missing]] and [[*Missing][that]].\n",
+			"<p>see <mark>*Missing</mark> and <mark>*Missing that</mark>.</p>\n",
+		},
+		"coderef": {
+			"see [[(nowhere)]].\n",
+			"<p>see <mark>(nowhere)</mark>.</p>\n",
+		},
+		"fallback": {
+			"see [[*Skip]].\n",
+			"<p>see Skip.</p>\n",
+		},
+		"resolved": {
+			"see [[*Found]].\n* Found\n",
+			"<p>see <a href=\"#found\" title=\"Found\">Found</a>.</p>\n\n<h1 id=\"found\">Found</h1>\n",
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.OnUnresolvedLink = func(link *Link) (string, bool) {
+		if link.Path == "*Skip" {
+			return "", false
+		}
+		if link.Description != "" {
+			return "<mark>" + link.Path + " " + link.Description + "</mark>", true
+		}
+		return "<mark>" + link.Path + "</mark>", true
+	}
+	testOrgWithOptions(testCases, opts, t)
+}
+
+func TestSlugFunc(t *testing.T) {
+	testCases := map[string]testCase{
+		"numeric-ids": {
+			"see [[*Second]].\n* First\n* Second\n",
+			"<p>see <a href=\"#sec-2\" title=\"Second\">Second</a>.</p>\n\n<h1 id=\"sec-1\">First</h1>\n\n<h1 id=\"sec-2\">Second</h1>\n",
+		},
+		"title-without-status-and-tags": {
+			"* TODO Task :work:\n[[*Task]]\n",
+			"<h1 id=\"sec-1\"><span class=\"todo TODO\">TODO</span> Task <span class=\"tags work\">work</span> </h1>\n\n<p><a href=\"#sec-1\" title=\"Task\">Task</a></p>\n",
+		},
+	}
+
+	opts := DefaultOptions()
+	opts.SlugFunc = func(title string, taken map[string]int) string {
+		return "sec-" + strconv.Itoa(len(taken)+1)
+	}
+	testOrgWithOptions(testCases, opts, t)
+}
+
 func TestRenderingFootnotes(t *testing.T) {
 	testCases := map[string]testCase{
 		"simple": {
 			"Test 1[fn:1] and Test 2[fn: 2] and Test 3[fn:3] also test lettres[fn:let] then final test[fn:4]\n\n[fn:let] what?\n\n\n[fn:6] And test it[fn:7].\n\n* Footnotes\n\n[fn:1] Test 1\n\n[fn:3] Test 3\n\n[fn:2] Test2\n\n[fn:5] missing?\n\n[fn:6] Six?\n\n[fn:7] Seven??",
-			"<p>Test 1<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup> and Test 2[fn: 2] and Test 3<sup class=\"footnote-ref\" id=\"fnref:3\"><a rel=\"footnote\" href=\"#fn:3\">2</a></sup> also test lettres<sup class=\"footnote-ref\" id=\"fnref:let\"><a rel=\"footnote\" href=\"#fn:let\">3</a></sup> then final test<sup class=\"footnote-ref\" id=\"fnref:4\"><a rel=\"footnote\" href=\"#fn:4\">4</a></sup></p>\n\n<h1 id=\"footnotes\">Footnotes</h1>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\">Test 1 <a class=\"footnote-return\" href=\"#fnref:1\"><sup>↩</sup></a></li>\n\n<li id=\"fn:3\">Test 3 <a class=\"footnote-return\" href=\"#fnref:3\"><sup>↩</sup></a></li>\n\n<li id=\"fn:let\">what? <a class=\"footnote-return\" href=\"#fnref:let\"><sup>↩</sup></a></li>\n\n<li id=\"fn:4\">DEFINITION NOT FOUND <a class=\"footnote-return\" href=\"#fnref:4\"><sup>↩</sup></a></li>\n</ol>\n</div>\n",
+			"<p>Test 1<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup> and Test 2[fn: 2] and Test 3<sup class=\"footnote-ref\" id=\"fnref:3\"><a rel=\"footnote\" href=\"#fn:3\">2</a></sup> also test lettres<sup class=\"footnote-ref\" id=\"fnref:let\"><a rel=\"footnote\" href=\"#fn:let\">3</a></sup> then final test[fn:4]</p>\n\n<h1 id=\"footnotes\">Footnotes</h1>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\">Test 1 <a class=\"footnote-return\" href=\"#fnref:1\"><sup>↩</sup></a></li>\n\n<li id=\"fn:3\">Test 3 <a class=\"footnote-return\" href=\"#fnref:3\"><sup>↩</sup></a></li>\n\n<li id=\"fn:let\">what? <a class=\"footnote-return\" href=\"#fnref:let\"><sup>↩</sup></a></li>\n</ol>\n</div>\n",
 		},
 		"multiline": {
 			"Test 1[fn:1]\n\n* Footnotes\n\n[fn:1] Test 1\nMore details",
 			"<p>Test 1<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup></p>\n\n<h1 id=\"footnotes\">Footnotes</h1>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\">Test 1 More details <a class=\"footnote-return\" href=\"#fnref:1\"><sup>↩</sup></a></li>\n</ol>\n</div>\n",
 		},
+		"definition-before-reference": {
+			"[fn:note] A named note\n\nSee[fn:note] and[fn:2]\n\n[fn:2] Two",
+			"<p>See<sup class=\"footnote-ref\" id=\"fnref:note\"><a rel=\"footnote\" href=\"#fn:note\">1</a></sup> and<sup class=\"footnote-ref\" id=\"fnref:2\"><a rel=\"footnote\" href=\"#fn:2\">2</a></sup></p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:note\">A named note <a class=\"footnote-return\" href=\"#fnref:note\"><sup>↩</sup></a></li>\n\n<li id=\"fn:2\">Two <a class=\"footnote-return\" href=\"#fnref:2\"><sup>↩</sup></a></li>\n</ol>\n</div>\n",
+		},
+		"missing-definition": {
+			"Only a reference[fn:nowhere]",
+			"<p>Only a reference[fn:nowhere]</p>\n",
+		},
+		"inline": {
+			"Inline[fn::note with *bold* and [[https://example.com][a link]]] then[fn:1] and[fn::second]\n\n[fn:1] Labeled",
+			"<p>Inline<sup class=\"footnote-ref\" id=\"fnref:inline-1\"><a rel=\"footnote\" href=\"#fn:inline-1\">1</a></sup> then<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">2</a></sup> and<sup class=\"footnote-ref\" id=\"fnref:inline-2\"><a rel=\"footnote\" href=\"#fn:inline-2\">3</a></sup></p>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:inline-1\">note with <strong>bold</strong> and <a href=\"https://example.com\" title=\"a link\">a link</a> <a class=\"footnote-return\" href=\"#fnref:inline-1\"><sup>↩</sup></a></li>\n\n<li id=\"fn:1\">Labeled <a class=\"footnote-return\" href=\"#fnref:1\"><sup>↩</sup></a></li>\n\n<li id=\"fn:inline-2\">second <a class=\"footnote-return\" href=\"#fnref:inline-2\"><sup>↩</sup></a></li>\n</ol>\n</div>\n",
+		},
+		"inline-empty-or-unclosed": {
+			"x[fn::] y[fn::open",
+			"<p>x[fn::] y[fn::open</p>\n",
+		},
 	}
 
 	for caseName, tc := range testCases {
@@ -459,19 +1295,27 @@ func TestRenderingBlock(t *testing.T) {
 		},
 		"EXAMPLE": {
 			"#+BEGIN_EXAMPLE sh\necho \"foo\"\n#+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\n</pre>\n",
 		},
 		"EXAMPLE_MULTILINE": {
 			"#+BEGIN_EXAMPLE sh\necho \"foo\"\necho \"bar\"\n#+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\necho &quot;bar&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\necho &quot;bar&quot;\n</pre>\n",
 		},
 		"EXAMPLE_MULTILINE_MULTI_NEWLINE": {
 			"#+BEGIN_EXAMPLE sh\necho \"foo\"\n\necho \"bar\"\n#+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\n\necho &quot;bar&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n</pre>\n",
 		},
 		"EXAMPLE_MULTILINE_MANY_MULTI_NEWLINE": {
 			"#+BEGIN_EXAMPLE sh\necho \"foo\"\n\necho \"bar\"\n\necho \"foo\"\n\necho \"bar\"\n#+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\n\necho &quot;bar&quot;\n\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n</pre>\n",
+		},
+		"EXAMPLE_NO_MARKUP": {
+			"#+BEGIN_EXAMPLE\n*asterisks* and /slashes/ <b>\n#+END_EXAMPLE\n",
+			"<pre class=\"example\">\n*asterisks* and /slashes/ &lt;b&gt;\n</pre>\n",
+		},
+		"FIXED_WIDTH": {
+			": *asterisks* <b>\n:   indented\n:\n",
+			"<pre class=\"example\">\n*asterisks* &lt;b&gt;\n  indented\n\n</pre>\n",
 		},
 		"QUOTE": {
 			"#+BEGIN_QUOTE\nthis is a quote.\n#+END_QUOTE\n",
@@ -481,6 +1325,30 @@ func TestRenderingBlock(t *testing.T) {
 			"#+BEGIN_QUOTE\nthis is a quote\nwith multiple lines.\n#+END_QUOTE\n",
 			"<blockquote>\n<p>\nthis is a quote\n</p>\n<p>\nwith multiple lines.\n</p>\n</blockquote>\n",
 		},
+		"QUOTE_LOWERCASE": {
+			"#+begin_quote  \nthis is a quote.\n#+end_quote \n",
+			"<blockquote>\n<p>\nthis is a quote.\n</p>\n</blockquote>\n",
+		},
+		"QUOTE_NESTED": {
+			"#+BEGIN_QUOTE\nouter\n#+BEGIN_QUOTE\ninner\n#+END_QUOTE\nafter\n#+END_QUOTE\n",
+			"<blockquote>\n<p>\nouter\n</p>\n<blockquote>\n<p>\ninner\n</p>\n</blockquote>\n<p>\nafter\n</p>\n</blockquote>\n",
+		},
+		"VERSE": {
+			"#+BEGIN_VERSE\nGreat *clouds* overhead\n  Tiny black birds\n\nrise and /fall/\n#+END_VERSE\n",
+			"<p class=\"verse\">\nGreat <strong>clouds</strong> overhead<br />\n&#xa0;&#xa0;Tiny black birds<br />\n<br />\nrise and <em>fall</em>\n</p>\n",
+		},
+		"EXPORT_HTML": {
+			"#+BEGIN_EXPORT html\n<div class=\"x\">*not bold* &amp;</div>\n\n<p>y</p>\n#+END_EXPORT\n",
+			"<div class=\"x\">*not bold* &amp;</div>\n\n<p>y</p>\n",
+		},
+		"EXPORT_OTHER_BACKEND": {
+			"#+BEGIN_EXPORT latex\n\\textbf{x}\n#+END_EXPORT\nafter\n",
+			"<p>after</p>\n",
+		},
+		"HTML": {
+			"#+BEGIN_HTML\n<b>x</b>\n#+END_HTML\n",
+			"<b>x</b>\n",
+		},
 		"CENTER": {
 			"#+BEGIN_CENTER\nthis is a centered block.\n#+END_CENTER\n",
 			"<center>\n<p>\nthis is a centered block.\n</p>\n</center>\n",
@@ -512,19 +1380,19 @@ func TestRenderingBlock(t *testing.T) {
 		},
 		"EXAMPLE_INDENTED": {
 			"\t\t  #+BEGIN_EXAMPLE sh\necho \"foo\"\n\t\t  #+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\n</pre>\n",
 		},
 		"EXAMPLE_MULTILINE_INDENTED": {
 			"        #+BEGIN_EXAMPLE sh\necho \"foo\"\necho \"bar\"\n        #+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\necho &quot;bar&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\necho &quot;bar&quot;\n</pre>\n",
 		},
 		"EXAMPLE_MULTILINE_MULTI_NEWLINE_INDENTED": {
 			" #+BEGIN_EXAMPLE sh\necho \"foo\"\n\necho \"bar\"\n #+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\n\necho &quot;bar&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n</pre>\n",
 		},
 		"EXAMPLE_MULTILINE_MANY_MULTI_NEWLINE_INDENTED": {
 			"   #+BEGIN_EXAMPLE sh\necho \"foo\"\n\necho \"bar\"\n\necho \"foo\"\n\necho \"bar\"\n   #+END_EXAMPLE\n",
-			"<pre><code class=\"language-sh\">echo &quot;foo&quot;\n\necho &quot;bar&quot;\n\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n</code></pre>\n",
+			"<pre class=\"example\">\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n\necho &quot;foo&quot;\n\necho &quot;bar&quot;\n</pre>\n",
 		},
 		"QUOTE_INDENTED": {
 			"\t\t\t#+BEGIN_QUOTE\nthis is a quote.\n\t\t\t#+END_QUOTE\n",
@@ -551,23 +1419,75 @@ func TestRenderingTables(t *testing.T) {
 	testCases := map[string]testCase{
 		"no-table-heading-no-horizontal-splits": {
 			"|foo|bar|baz|\n| d | e | f |\n| g | h | i |\n",
-			"\n<table>\n<tbody>\n<tr>\n<td>foo</td>\n<td>bar</td>\n<td>baz</td>\n</tr>\n\n<tr>\n<td>d</td>\n<td>e</td>\n<td>f</td>\n</tr>\n\n<tr>\n<td>g</td>\n<td>h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
+			"<table>\n<tbody>\n<tr>\n<td>foo</td>\n<td>bar</td>\n<td>baz</td>\n</tr>\n\n<tr>\n<td>d</td>\n<td>e</td>\n<td>f</td>\n</tr>\n\n<tr>\n<td>g</td>\n<td>h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
 		},
 		"table-heading": {
 			"|foo|bar|baz|\n|---+---+---|\n| d | e | f |\n| g | h | i |\n",
-			"\n<table>\n<thead>\n<tr>\n<th>foo</th>\n<th>bar</th>\n<th>baz</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>d</td>\n<td>e</td>\n<td>f</td>\n</tr>\n\n<tr>\n<td>g</td>\n<td>h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
+			"<table>\n<thead>\n<tr>\n<th>foo</th>\n<th>bar</th>\n<th>baz</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>d</td>\n<td>e</td>\n<td>f</td>\n</tr>\n\n<tr>\n<td>g</td>\n<td>h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
 		},
 		"no-table-heading-horizontal-splits": {
 			"|---+---+---|\n| d | e | f |\n|---+---+---|\n| g | h | i |\n|---+---+---|\n",
-			"\n<table>\n<tbody>\n<tr>\n<td>d</td>\n<td>e</td>\n<td>f</td>\n</tr>\n\n<tr>\n<td>g</td>\n<td>h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
+			"<table>\n<tbody>\n<tr>\n<td>d</td>\n<td>e</td>\n<td>f</td>\n</tr>\n\n<tr>\n<td>g</td>\n<td>h</td>\n<td>i</td>\n</tr>\n</tbody>\n</table>\n",
 		},
 		"table-with-inlined-elements": {
 			"| Format           | Org mode markup syntax |\n| *Bold*           | =*Bold*=               |\n| /Italics/        | =/Italics/=            |\n| _Underline_      | =_Underline_=          |\n| =Verbatim=       | ==Verbatim== |\n| +Strike-through+ | =+Strike-through+=     |\n",
-			"\n<table>\n<tbody>\n<tr>\n<td>Format</td>\n<td>Org mode markup syntax</td>\n</tr>\n\n<tr>\n<td><strong>Bold</strong></td>\n<td><code>*Bold*</code></td>\n</tr>\n\n<tr>\n<td><em>Italics</em></td>\n<td><code>/Italics/</code></td>\n</tr>\n\n<tr>\n<td><span style=\"text-decoration: underline;\">Underline</span></td>\n<td><code>_Underline_</code></td>\n</tr>\n\n<tr>\n<td><code>Verbatim</code></td>\n<td><code>=Verbatim=</code></td>\n</tr>\n\n<tr>\n<td><del>Strike-through</del></td>\n<td><code>+Strike-through+</code></td>\n</tr>\n</tbody>\n</table>\n",
+			"<table>\n<tbody>\n<tr>\n<td>Format</td>\n<td>Org mode markup syntax</td>\n</tr>\n\n<tr>\n<td><strong>Bold</strong></td>\n<td><code>*Bold*</code></td>\n</tr>\n\n<tr>\n<td><em>Italics</em></td>\n<td><code>/Italics/</code></td>\n</tr>\n\n<tr>\n<td><span class=\"underline\">Underline</span></td>\n<td><code>_Underline_</code></td>\n</tr>\n\n<tr>\n<td><code>Verbatim</code></td>\n<td><code>=Verbatim=</code></td>\n</tr>\n\n<tr>\n<td><del>Strike-through</del></td>\n<td><code>+Strike-through+</code></td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-padded-cells": {
+			"|   foo  | bar baz  |\n|   =  d = |  e\t|\n",
+			"<table>\n<tbody>\n<tr>\n<td>foo</td>\n<td>bar baz</td>\n</tr>\n\n<tr>\n<td>=  d =</td>\n<td>e</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-links": {
+			"| [[https://a.com][A]] | *b* |\n|---+---|\n| [[https://c.com][C]] | d |\n",
+			"<table>\n<thead>\n<tr>\n<th><a href=\"https://a.com\" title=\"A\">A</a></th>\n<th><strong>b</strong></th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td><a href=\"https://c.com\" title=\"C\">C</a></td>\n<td>d</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-html-special-characters": {
+			"| a < b | \"c\" & d |\n",
+			"<table>\n<tbody>\n<tr>\n<td>a &lt; b</td>\n<td>&quot;c&quot; &amp; d</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-vert-entity": {
+			"| \\vert | a \\vert{} b |\n",
+			"<table>\n<tbody>\n<tr>\n<td>|</td>\n<td>a | b</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-caption": {
+			"#+CAPTION: Some /numbers/\n| a | b |\n|---+---|\n| 1 | 2 |\n",
+			"<table>\n<caption>Some <em>numbers</em></caption>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-caption-not-adjacent": {
+			"#+CAPTION: a paragraph\nText\n| r |\n",
+			"<p>Text</p>\n\n<table>\n<tbody>\n<tr>\n<td>r</td>\n</tr>\n</tbody>\n</table>\n",
 		},
 		"table-single-cell": {
 			"| r |\n",
-			"\n<table>\n<tbody>\n<tr>\n<td>r</td>\n</tr>\n</tbody>\n</table>\n",
+			"<table>\n<tbody>\n<tr>\n<td>r</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-ragged-rows": {
+			"| a | b | c |\n|---+---+---|\n| 1 |\n|  | x |\n",
+			"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n<th>c</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td></td>\n<td></td>\n</tr>\n\n<tr>\n<td></td>\n<td>x</td>\n<td></td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-indented": {
+			"  | a | b |\n  | 1 | 2\n",
+			"<table>\n<tbody>\n<tr>\n<td>a</td>\n<td>b</td>\n</tr>\n\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-escaped-pipe": {
+			"| a \\| b | c |\n",
+			"<table>\n<tbody>\n<tr>\n<td>a | b</td>\n<td>c</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-pipe-in-code": {
+			"| ~a|b~ | =c|d= | e |\n| 1 | 2 | 3 |\n",
+			"<table>\n<tbody>\n<tr>\n<td><code>a|b</code></td>\n<td><code>c|d</code></td>\n<td>e</td>\n</tr>\n\n<tr>\n<td>1</td>\n<td>2</td>\n<td>3</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-unclosed-code-marker": {
+			"| a~b | c~ |\n",
+			"<table>\n<tbody>\n<tr>\n<td>a~b</td>\n<td>c~</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-centered-column": {
+			"| a | b | c |\n|---+:-:+---|\n| 1 | 2 | 3 |\n",
+			"<table>\n<thead>\n<tr>\n<th>a</th>\n<th align=\"center\">b</th>\n<th>c</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td align=\"center\">2</td>\n<td>3</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+		"table-left-right-columns": {
+			"| a | b |\n|:--|--:|\n| 1 | 2 |\n",
+			"<table>\n<thead>\n<tr>\n<th align=\"left\">a</th>\n<th align=\"right\">b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td align=\"left\">1</td>\n<td align=\"right\">2</td>\n</tr>\n</tbody>\n</table>\n",
 		},
 	}
 
@@ -580,6 +1500,42 @@ func TestLists(t *testing.T) {
 			"- definition lists :: these are useful sometimes\n- item 2 :: M-RET again gives another item, and long lines wrap in a tidy way underneath the definition\n",
 			"<dl>\n<dt>definition lists</dt>\n<dd>these are useful sometimes</dd>\n<dt>item 2</dt>\n<dd>M-RET again gives another item, and long lines wrap in a tidy way underneath the definition</dd>\n</dl>\n",
 		},
+		"definition-checkboxes": {
+			"- [X] done term :: its description\n- [ ] open term :: another\n",
+			"<dl>\n<dt><input type=\"checkbox\" checked disabled /> done term</dt>\n<dd>its description</dd>\n<dt><input type=\"checkbox\" disabled /> open term</dt>\n<dd>another</dd>\n</dl>\n",
+		},
+		"ul-checkboxes": {
+			"- [-] partly\n- [ ] not yet\n",
+			"<ul>\n<li><input type=\"checkbox\" class=\"indeterminate\" disabled /> partly</li>\n<li><input type=\"checkbox\" disabled /> not yet</li>\n</ul>\n",
+		},
+		"ol-checkbox": {
+			"1. [X] first\n2. [@5] counter\n",
+			"<ol>\n<li><input type=\"checkbox\" checked disabled /> first</li>\n<li value=\"5\">counter</li>\n</ol>\n",
+		},
+		"unmatched-emphasis-stays-in-item": {
+			"- a *b\n- c* d\n",
+			"<ul>\n<li>a *b</li>\n<li>c* d</li>\n</ul>\n",
+		},
+		"emphasis-within-item": {
+			"- a *b* c\n- d\n",
+			"<ul>\n<li>a <strong>b</strong> c</li>\n<li>d</li>\n</ul>\n",
+		},
+		"emphasis-across-item-lines": {
+			"- a *b\n  c* d\n- e /f\n- g/\n",
+			"<ul>\n<li>a <strong>b\nc</strong> d</li>\n<li>e /f</li>\n<li>g/</li>\n</ul>\n",
+		},
+		"definition-across-lines": {
+			"- t :: a =b\n  c= d\n",
+			"<dl>\n<dt>t</dt>\n<dd>a <code>b\nc</code> d</dd>\n</dl>\n",
+		},
+		"ol-counter-and-checkbox": {
+			"1. [ ] a\n2. [@3] [X] b\n3. c\n",
+			"<ol>\n<li><input type=\"checkbox\" disabled /> a</li>\n<li value=\"3\"><input type=\"checkbox\" checked disabled /> b</li>\n<li>c</li>\n</ol>\n",
+		},
+		"ol-checkbox-before-counter": {
+			"1. [X] [@3] b\n",
+			"<ol>\n<li><input type=\"checkbox\" checked disabled /> [@3] b</li>\n</ol>\n",
+		},
 		"simple-ol": {
 			"1. this\n2. is\n3. an\n4. ordered\n5. list\n",
 			"<ol>\n<li>this</li>\n<li>is</li>\n<li>an</li>\n<li>ordered</li>\n<li>list</li>\n</ol>\n",
@@ -596,9 +1552,105 @@ func TestLists(t *testing.T) {
 			"- this\n- is\n- an\n- unordered\n- list\n",
 			"<ul>\n<li>this</li>\n<li>is</li>\n<li>an</li>\n<li>unordered</li>\n<li>list</li>\n</ul>\n",
 		},
+		"ul-no-trailing-newline": {
+			"- this\n- list",
+			"<ul>\n<li>this</li>\n<li>list</li>\n</ul>\n",
+		},
+		"ul-emphasis-across-items": {
+			"- a /b\n- c/ d\n",
+			"<ul>\n<li>a /b</li>\n<li>c/ d</li>\n</ul>\n",
+		},
+		"ul-footnote": {
+			"- an item with a note[fn:1]\n\n[fn:1] The note.\n",
+			"<ul>\n<li>an item with a note<sup class=\"footnote-ref\" id=\"fnref:1\"><a rel=\"footnote\" href=\"#fn:1\">1</a></sup></li>\n</ul>\n<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n<li id=\"fn:1\">The note.</li>\n</ol>\n</div>\n",
+		},
+		"ul-bullet-change": {
+			"- this\n- list\n+ another\n+ list\n",
+			"<ul>\n<li>this</li>\n<li>list</li>\n</ul>\n\n<ul>\n<li>another</li>\n<li>list</li>\n</ul>\n",
+		},
+		"ol-then-ul": {
+			"1. this\n2. is ordered\n- this is not\n",
+			"<ol>\n<li>this</li>\n<li>is ordered</li>\n</ol>\n\n<ul>\n<li>this is not</li>\n</ul>\n",
+		},
+		"ol-paren-markers": {
+			"1) this\n2. is one\n3) list\n",
+			"<ol>\n<li>this</li>\n<li>is one</li>\n<li>list</li>\n</ol>\n",
+		},
+		"ol-start-value": {
+			"3. three\n4. four\n",
+			"<ol>\n<li value=\"3\">three</li>\n<li>four</li>\n</ol>\n",
+		},
+		"ol-no-space": {
+			"3.not a list\n",
+			"<p>3.not a list</p>\n",
+		},
+		"ol-after-paragraph": {
+			"Steps:\n1. one\n",
+			"<p>Steps:</p>\n\n<ol>\n<li>one</li>\n</ol>\n",
+		},
+		"nested-three-levels": {
+			"- a\n  - b\n    - c\n  - d\n- e\n",
+			"<ul>\n<li>a\n<ul>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n</li>\n<li>e</li>\n</ul>\n",
+		},
+		"nested-dedent-two-levels": {
+			"- a\n  - b\n    - c\n- d\n",
+			"<ul>\n<li>a\n<ul>\n<li>b\n<ul>\n<li>c</li>\n</ul>\n</li>\n</ul>\n</li>\n<li>d</li>\n</ul>\n",
+		},
+		"nested-ol-in-ul": {
+			"- a\n  1. b\n  2. c\n",
+			"<ul>\n<li>a\n<ol>\n<li>b</li>\n<li>c</li>\n</ol>\n</li>\n</ul>\n",
+		},
+		"item-continuation": {
+			"- a\n  more text\n  - b\n- c\n",
+			"<ul>\n<li>a\nmore text\n<ul>\n<li>b</li>\n</ul>\n</li>\n<li>c</li>\n</ul>\n",
+		},
+		"list-then-paragraph": {
+			"- a\n  - b\nafter\n",
+			"<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n\n<p>after</p>\n",
+		},
+		"tab-sublist-under-spaces": {
+			"  - a\n\t- b\n  - c\n",
+			"<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n<li>c</li>\n</ul>\n",
+		},
+		"space-sublist-under-tab": {
+			"\t- a\n\t  - b\n",
+			"<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n",
+		},
+		"mixed-indentation-by-column": {
+			"\t- a\n    - b\n",
+			"<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n",
+		},
 	}
 
 	testOrgCommon(testCases, t)
+
+	tabCases := map[string]testCase{
+		"tab-at-width": {
+			"- a\n  - b\n\t- c\n",
+			"<ul>\n<li>a\n<ul>\n<li>b</li>\n<li>c</li>\n</ul>\n</li>\n</ul>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.TabWidth = 2
+	testOrgWithOptions(tabCases, opts, t)
+}
+
+func TestCheckboxInputs(t *testing.T) {
+	testCases := map[string]testCase{
+		"checkbox-states": {
+			"- [ ] open\n- [X] done\n- [x] also done\n- [-] partly\n",
+			"<ul>\n<li><input type=\"checkbox\" disabled /> open</li>\n<li><input type=\"checkbox\" checked disabled /> done</li>\n<li><input type=\"checkbox\" checked disabled /> also done</li>\n<li><input type=\"checkbox\" class=\"indeterminate\" disabled /> partly</li>\n</ul>\n",
+		},
+		"ol-counter-and-checkbox": {
+			"1. [@3] [X] b\n",
+			"<ol>\n<li value=\"3\"><input type=\"checkbox\" checked disabled /> b</li>\n</ol>\n",
+		},
+		"not-checkboxes": {
+			"- [] empty\n- later [ ] box\n",
+			"<ul>\n<li>[] empty</li>\n<li>later [ ] box</li>\n</ul>\n",
+		},
+	}
+	testOrgWithOptions(testCases, DefaultOptions(), t)
 }
 
 func TestRenderingPropertiesDrawer(t *testing.T) {
@@ -612,6 +1664,47 @@ func TestRenderingPropertiesDrawer(t *testing.T) {
 	testOrgCommon(testCases, t)
 }
 
+func TestDrawerMode(t *testing.T) {
+	in := "* Heading\n:LOGBOOK:\n- Note <taken>\n:END:\ntext\n"
+
+	hiddenCases := map[string]testCase{
+		"logbook": {
+			in,
+			"<h1 id=\"heading\">Heading</h1>\n\n<p>text</p>\n",
+		},
+		"drawer-in-paragraph": {
+			"before\n:NOTES:\nhidden\n\nstill hidden\n:END:\nafter\n",
+			"<p>before</p>\n\n<p>after</p>\n",
+		},
+	}
+	testOrgWithOptions(hiddenCases, DefaultOptions(), t)
+
+	shownCases := map[string]testCase{
+		"logbook": {
+			in,
+			"<h1 id=\"heading\">Heading</h1>\n\n<pre class=\"drawer logbook\">\n- Note &lt;taken&gt;\n</pre>\n\n<p>text</p>\n",
+		},
+		"properties": {
+			"* Heading\n:PROPERTIES:\n:ID: x\n:END:\n",
+			"<h1 id=\"heading\">Heading</h1>\n\n<pre class=\"drawer properties\">\n:ID: x\n</pre>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.DrawerMode = ShowDrawers
+	testOrgWithOptions(shownCases, opts, t)
+
+	customCases := map[string]testCase{
+		"logbook": {
+			in,
+			"<h1 id=\"heading\">Heading</h1>\n<details>LOGBOOK: 1 lines</details>\n\n<p>text</p>\n",
+		},
+	}
+	opts.DrawerRender = func(name string, lines []string) string {
+		return "<details>" + name + ": " + strconv.Itoa(len(lines)) + " lines</details>\n"
+	}
+	testOrgWithOptions(customCases, opts, t)
+}
+
 func TestRenderingComplexTexts(t *testing.T) {
 	testCases := map[string]testCase{
 		"newline": {
@@ -652,6 +1745,410 @@ func TestFixedWidthAreas(t *testing.T) {
 	testOrgCommon(testCases, t)
 }
 
+func TestRenderingHeadlineIDs(t *testing.T) {
+	testCases := map[string]testCase{
+		"custom-id": {
+			"see [[*Setup]]\n* Setup\n:PROPERTIES:\n:CUSTOM_ID: install\n:END:\n",
+			"<p>see <a href=\"#install\" title=\"Setup\">Setup</a></p>\n\n<h1 id=\"install\">Setup</h1>\n",
+		},
+		"tagged": {
+			"see [[*Foo]]\n* Foo :tag:\n",
+			"<p>see <a href=\"#foo\" title=\"Foo\">Foo</a></p>\n\n<h1 id=\"foo\">Foo <span class=\"tags tag\">tag</span> </h1>\n",
+		},
+		"repeated-titles": {
+			"* Notes\n* Notes\n* Notes\n",
+			"<h1 id=\"notes\">Notes</h1>\n\n<h1 id=\"notes-1\">Notes</h1>\n\n<h1 id=\"notes-2\">Notes</h1>\n",
+		},
+	}
+	testOrgWithOptions(testCases, DefaultOptions(), t)
+
+	omitCases := map[string]testCase{
+		"omit-ids": {
+			"* Setup\n:PROPERTIES:\n:CUSTOM_ID: install\n:END:\n** Details\n",
+			"<h1>Setup</h1>\n\n<h2>Details</h2>\n",
+		},
+		"omit-ids-link": {
+			"see [[*Setup]] and [[*Setup][the setup]]\n* Setup\n",
+			"<p>see Setup and the setup</p>\n\n<h1>Setup</h1>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.OmitHeadlineIDs = true
+	testOrgWithOptions(omitCases, opts, t)
+}
+
+func TestTodoKeywords(t *testing.T) {
+	testCases := map[string]testCase{
+		"custom-keyword": {
+			"* WAITING reply\n",
+			"<h1 id=\"reply\"><span class=\"todo WAITING\">WAITING</span> reply</h1>\n",
+		},
+		"default-keyword-not-in-set": {
+			"* TODO reply\n",
+			"<h1 id=\"todo-reply\">TODO reply</h1>\n",
+		},
+		"keyword-without-title": {
+			"* NEXT\n",
+			"<h1><span class=\"todo NEXT\">NEXT</span> </h1>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.TodoKeywords = []string{"NEXT", "WAITING"}
+	testOrgWithOptions(testCases, opts, t)
+}
+
+func TestUnderline(t *testing.T) {
+	modes := map[UnderlineMode]string{
+		UnderlineSpan: "<p><span class=\"underline\">a <strong>b</strong> c</span></p>\n",
+		UnderlineU:    "<p><u>a <strong>b</strong> c</u></p>\n",
+		UnderlineIns:  "<p><ins>a <strong>b</strong> c</ins></p>\n",
+	}
+	for mode, expected := range modes {
+		opts := DefaultOptions()
+		opts.Underline = mode
+		testOrgWithOptions(map[string]testCase{
+			"underline-mode-" + strconv.Itoa(int(mode)): {"_a *b* c_\n", expected},
+		}, opts, t)
+	}
+}
+
+func TestParagraphTag(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ParagraphTag = "div"
+	testOrgWithOptions(map[string]testCase{
+		"custom-tag": {
+			"a\n\nb\n#+BEGIN_QUOTE\nq\n#+END_QUOTE\n",
+			"<div>a</div>\n\n<div>b</div>\n\n<blockquote>\n<div>\nq\n</div>\n</blockquote>\n",
+		},
+	}, opts, t)
+
+	opts = DefaultOptions()
+	opts.UnwrapParagraphs = true
+	testOrgWithOptions(map[string]testCase{
+		"unwrapped": {
+			"a\n\nb\n",
+			"a\nb\n",
+		},
+	}, opts, t)
+
+	opts.ParagraphBreak = "<br><br>"
+	testOrgWithOptions(map[string]testCase{
+		"unwrapped-with-break": {
+			"a\nline\n\nb\n\n- x\n\nc\n",
+			"a\nline\n<br><br>b\n\n<ul>\n<li>x</li>\n</ul>\n\nc\n",
+		},
+	}, opts, t)
+}
+
+func TestHeadlineSelfLink(t *testing.T) {
+	source := "./testdata/headline-anchors.org"
+	golden := "./testdata/headline-anchors.html.golden"
+	in, err := ioutil.ReadFile(source)
+	if err != nil {
+		t.Fatalf("failed to read %s file: %s", source, err)
+	}
+
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	opts := DefaultOptions()
+	opts.HeadlineSelfLink = true
+	out, err := OrgWithOptions(in, renderer, opts)
+	if err != nil {
+		t.Fatalf("OrgWithOptions() from %s failed: %s", source, err)
+	}
+
+	if *update {
+		if err := ioutil.WriteFile(golden, out, 0644); err != nil {
+			t.Errorf("failed to write %s file: %s", golden, err)
+		}
+		return
+	}
+
+	gld, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read %s file: %s", golden, err)
+	}
+	if !bytes.Equal(out, gld) {
+		t.Errorf("OrgWithOptions() with HeadlineSelfLink from %s = %s\nwants: %s", source, out, gld)
+	}
+
+	opts.SelfLinkSymbol = "#"
+	out, _ = OrgWithOptions([]byte("* A\n"), renderer, opts)
+	if expected := "<h1 id=\"a\">A <a class=\"headline-anchor\" href=\"#a\">#</a></h1>\n"; string(out) != expected {
+		t.Errorf("OrgWithOptions() with SelfLinkSymbol = %q\nwants: %q", out, expected)
+	}
+
+	// the link follows the id the renderer writes, with its prefix and suffix, and
+	// stays unique when the renderer is used again
+	opts.SelfLinkSymbol = ""
+	renderer = blackfriday.HtmlRendererWithParameters(blackfriday.HTML_USE_XHTML, "", "", blackfriday.HtmlRendererParameters{HeaderIDPrefix: "p-", HeaderIDSuffix: "-s"})
+	expected := []string{
+		"<h1 id=\"p-a-s\">A <a class=\"headline-anchor\" href=\"#p-a-s\">¶</a></h1>\n",
+		"<h1 id=\"p-a-1-s\">A <a class=\"headline-anchor\" href=\"#p-a-1-s\">¶</a></h1>\n",
+	}
+	for i := range expected {
+		out, _ = OrgWithOptions([]byte("* A\n"), renderer, opts)
+		if string(out) != expected[i] {
+			t.Errorf("OrgWithOptions() with HeadlineSelfLink and HeaderIDPrefix, render %d = %q\nwants: %q", i+1, out, expected[i])
+		}
+	}
+}
+
+// nestedBlockIn has lines that look like a headline, a footnote and emphasis after a
+// #+END_SRC that is part of the body of an example block
+const nestedBlockIn = "#+BEGIN_EXAMPLE\n#+BEGIN_SRC sh\necho a\n#+END_SRC\n* Fake\n[fn:x] not a note\n*open\n#+END_EXAMPLE\n** Real\nsee [[*Fake]] and[fn:x]\n"
+
+func TestNestedBlockPrePasses(t *testing.T) {
+	testCases := map[string]testCase{
+		"nested-block": {
+			nestedBlockIn,
+			"<pre class=\"example\">\n#+BEGIN_SRC sh\necho a\n#+END_SRC\n* Fake\n[fn:x] not a note\n*open\n</pre>\n\n<h1 id=\"real\">Real</h1>\n\n<p>see Fake and[fn:x]</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.BaseHeadlineLevel = 1
+	testOrgWithOptions(testCases, opts, t)
+}
+
+func TestHeadlineLinkRendererIDs(t *testing.T) {
+	// [[*Title]] links point at the id the renderer writes for the headline, even
+	// when the headline comes after the link
+	in := []byte("see [[*A]]\n* A\n")
+	renderer := blackfriday.HtmlRendererWithParameters(blackfriday.HTML_USE_XHTML, "", "", blackfriday.HtmlRendererParameters{HeaderIDPrefix: "p-", HeaderIDSuffix: "-s"})
+	expected := []string{
+		"<p>see <a href=\"#p-a-s\" title=\"A\">A</a></p>\n\n<h1 id=\"p-a-s\">A</h1>\n",
+		"<p>see <a href=\"#p-a-1-s\" title=\"A\">A</a></p>\n\n<h1 id=\"p-a-1-s\">A</h1>\n",
+	}
+	for i := range expected {
+		out, err := OrgWithOptions(in, renderer, DefaultOptions())
+		if err != nil {
+			t.Fatalf("OrgWithOptions() from %s failed: %s", in, err)
+		}
+		if string(out) != expected[i] {
+			t.Errorf("OrgWithOptions() with HeaderIDPrefix from %s, render %d = %q\nwants: %q", in, i+1, out, expected[i])
+		}
+	}
+}
+
+func TestCollapseSingleItemLists(t *testing.T) {
+	testCases := map[string]testCase{
+		"single-item": {
+			"- only *one* item\n  continued\n\nafter\n",
+			"<p>only <strong>one</strong> item\ncontinued</p>\n\n<p>after</p>\n",
+		},
+		"two-items": {
+			"- a\n- b\n",
+			"<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n",
+		},
+		"checkbox": {
+			"- [X] done\n",
+			"<ul>\n<li><input type=\"checkbox\" checked disabled /> done</li>\n</ul>\n",
+		},
+		"ordered": {
+			"1. one\n",
+			"<ol>\n<li>one</li>\n</ol>\n",
+		},
+		"nested": {
+			"- a\n  - b\n",
+			"<ul>\n<li>a\n<ul>\n<li>b</li>\n</ul>\n</li>\n</ul>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.CollapseSingleItemLists = true
+	testOrgWithOptions(testCases, opts, t)
+
+	testOrgWithOptions(map[string]testCase{
+		"disabled": {
+			"- only one item\n",
+			"<ul>\n<li>only one item</li>\n</ul>\n",
+		},
+	}, DefaultOptions(), t)
+}
+
+func TestStableOutput(t *testing.T) {
+	in := "* TODO [#A] Task :work:\ntext  \nmore \t\n\n- [X] done  \n- a :: b  \n\n#+BEGIN_QUOTE\nquoted  \n#+END_QUOTE\n\n#+BEGIN_SRC go -r\nx := 1 // (ref:one)\n#+END_SRC\n\nsee [[(one)]] and _under_\n-----\n"
+	expected := []string{
+		`<h1 id="task"><span class="todo TODO">TODO</span> <span class="priority A">[#A]</span>`,
+		`<input type="checkbox" checked disabled />`,
+		`<span id="coderef-one" class="coderef">(one)</span>`,
+		`<hr class="rule" />`,
+	}
+
+	opts := DefaultOptions()
+	opts.HorizontalRuleClass = "rule"
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	first, err := OrgWithOptions([]byte(in), renderer, opts)
+	if err != nil {
+		t.Fatalf("OrgWithOptions() from %s returned an error: %s", in, err)
+	}
+	for _, want := range expected {
+		if !bytes.Contains(first, []byte(want)) {
+			t.Errorf("OrgWithOptions() from %s = %s\nwants it to contain: %s", in, first, want)
+		}
+	}
+	for i, line := range bytes.Split(first, []byte("\n")) {
+		if len(line) > 0 && isSpace(line[len(line)-1]) {
+			t.Errorf("line %d of OrgWithOptions() from %s has trailing whitespace: %q", i+1, in, line)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+		out, _ := OrgWithOptions([]byte(in), renderer, opts)
+		if !bytes.Equal(out, first) {
+			t.Fatalf("OrgWithOptions() from %s = %s\nwants the same output as before: %s", in, out, first)
+		}
+	}
+}
+
+func TestTrimDocument(t *testing.T) {
+	testCases := map[string]testCase{
+		"surrounding-blank-lines": {
+			"\n \n\t\n| a |\n\ntext\n\n\n",
+			"<table>\n<tbody>\n<tr>\n<td>a</td>\n</tr>\n</tbody>\n</table>\n\n<p>text</p>\n",
+		},
+		"only-blank-lines": {
+			"\n\n\n",
+			"",
+		},
+	}
+	testOrgWithOptions(testCases, DefaultOptions(), t)
+
+	untrimmedCases := map[string]testCase{
+		"untrimmed": {
+			"\n\n| a |\n\n",
+			"\n<table>\n<tbody>\n<tr>\n<td>a</td>\n</tr>\n</tbody>\n</table>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.TrimDocument = false
+	testOrgWithOptions(untrimmedCases, opts, t)
+}
+
+func TestHorizontalRules(t *testing.T) {
+	testCases := map[string]testCase{
+		"rule-between-paragraphs": {
+			"para one\n-----\npara two\n",
+			"<p>para one</p>\n\n<hr />\n\n<p>para two</p>\n",
+		},
+		"rule-in-quote": {
+			"#+BEGIN_QUOTE\nquoted\n-------\nmore\n#+END_QUOTE\n",
+			"<blockquote>\n<p>\nquoted\n</p>\n\n<hr />\n<p>\nmore\n</p>\n</blockquote>\n",
+		},
+		"rule-after-list": {
+			"- item\n-----\n",
+			"<ul>\n<li>item</li>\n</ul>\n\n<hr />\n",
+		},
+		"indented-rule": {
+			"  ---------  \n",
+			"<hr />\n",
+		},
+		"four-dashes": {
+			"----\n",
+			"<p>----</p>\n",
+		},
+		"list-item-of-dashes": {
+			"- -----\n",
+			"<ul>\n<li>-----</li>\n</ul>\n",
+		},
+	}
+	testOrgCommon(testCases, t)
+
+	classCases := map[string]testCase{
+		"rule-with-class": {
+			"a\n-----\nb\n",
+			"<p>a</p>\n\n<hr class=\"rule\" />\n\n<p>b</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.HorizontalRuleClass = "rule"
+	testOrgWithOptions(classCases, opts, t)
+}
+
+func TestContentHash(t *testing.T) {
+	base := ContentHash([]byte("* A headline\n| a | b |\n\nSome *text*.\n"))
+
+	same := map[string]string{
+		"headline-whitespace": "* A headline   \n| a | b |\n\nSome *text*.\n",
+		"table-padding":       "* A headline\n|a|   b |\n\nSome *text*.\n",
+		"extra-empty-lines":   "* A headline\n| a | b |\n\n\n\nSome *text*.\n",
+	}
+	for caseName, in := range same {
+		if hash := ContentHash([]byte(in)); hash != base {
+			t.Errorf("case %s for ContentHash() from %s = %s\nwants: %s", caseName, in, hash, base)
+		}
+	}
+
+	if hash := ContentHash([]byte("* A headline\n| a | c |\n\nSome *text*.\n")); hash == base {
+		t.Errorf("ContentHash() of changed content = %s\nwants a different hash", hash)
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	testCases := map[string]struct {
+		in         string
+		lineEnding string
+		expected   string
+	}{
+		"default": {
+			"* Heading\nA paragraph.\n#+BEGIN_SRC sh\necho \"foo\"\necho \"bar\"\n#+END_SRC\n",
+			"",
+			"<h1 id=\"heading\">Heading</h1>\n\n<p>A paragraph.</p>\n\n<pre><code class=\"language-sh\">echo &quot;foo&quot;\necho &quot;bar&quot;\n</code></pre>\n",
+		},
+		"crlf": {
+			"* Heading\nA paragraph.\n#+BEGIN_SRC sh\necho \"foo\"\necho \"bar\"\n#+END_SRC\n",
+			"\r\n",
+			"<h1 id=\"heading\">Heading</h1>\r\n\r\n<p>A paragraph.</p>\r\n\r\n<pre><code class=\"language-sh\">echo &quot;foo&quot;\r\necho &quot;bar&quot;\r\n</code></pre>\r\n",
+		},
+		"crlf-input": {
+			"* Heading\r\n#+BEGIN_SRC sh\r\necho \"foo\"\r\necho \"bar\"\r\n#+END_SRC\r\n",
+			"\r\n",
+			"<h1 id=\"heading\">Heading</h1>\r\n\r\n<pre><code class=\"language-sh\">echo &quot;foo&quot;\r\necho &quot;bar&quot;\r\n</code></pre>\r\n",
+		},
+	}
+
+	for caseName, tc := range testCases {
+		opts := DefaultOptions()
+		opts.LineEnding = tc.lineEnding
+		renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+
+		out, err := OrgWithOptions([]byte(tc.in), renderer, opts)
+		if err != nil {
+			t.Fatalf("case %s for OrgWithOptions() returned an error: %s", caseName, err)
+		}
+		if !bytes.Equal(out, []byte(tc.expected)) {
+			t.Errorf("case %s for OrgWithOptions() from %q = %q\nwants: %q", caseName, tc.in, out, tc.expected)
+		}
+	}
+
+	renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+	if _, err := OrgWithOptions([]byte("text\n"), renderer, Options{LineEnding: "\r"}); err == nil {
+		t.Errorf("OrgWithOptions() with LineEnding %q should return an error", "\r")
+	}
+}
+
+func TestMaxHeadlineDepth(t *testing.T) {
+	in := "* One\nintro\n** Two\nbody\n*** Three :tag:\ndeep text\n#+BEGIN_SRC org\n* not a headline\n#+END_SRC\n** Back\nback text\n"
+
+	dropped := map[string]testCase{
+		"drop-depth-2": {
+			in,
+			"<h1 id=\"one\">One</h1>\n\n<p>intro</p>\n\n<h2 id=\"two\">Two</h2>\n\n<p>body</p>\n\n<h2 id=\"back\">Back</h2>\n\n<p>back text</p>\n",
+		},
+	}
+	opts := DefaultOptions()
+	opts.MaxHeadlineDepth = 2
+	testOrgWithOptions(dropped, opts, t)
+
+	flattened := map[string]testCase{
+		"flatten-depth-2": {
+			in,
+			"<h1 id=\"one\">One</h1>\n\n<p>intro</p>\n\n<h2 id=\"two\">Two</h2>\n\n<p>body</p>\n\n<p>Three</p>\n\n<p>deep text</p>\n\n<pre><code class=\"language-org\">* not a headline\n</code></pre>\n\n<h2 id=\"back\">Back</h2>\n\n<p>back text</p>\n",
+		},
+	}
+	opts.DeepHeadlines = FlattenDeepHeadlines
+	testOrgWithOptions(flattened, opts, t)
+}
+
 func testOrgCommon(testCases map[string]testCase, t *testing.T) {
 	for caseName, tc := range testCases {
 
@@ -662,3 +2159,18 @@ func testOrgCommon(testCases map[string]testCase, t *testing.T) {
 		}
 	}
 }
+
+func testOrgWithOptions(testCases map[string]testCase, opts Options, t *testing.T) {
+	for caseName, tc := range testCases {
+		renderer := blackfriday.HtmlRenderer(blackfriday.HTML_USE_XHTML, "", "")
+
+		out, err := OrgWithOptions([]byte(tc.in), renderer, opts)
+		if err != nil {
+			t.Errorf("case %s for OrgWithOptions() from %s returned an error: %s", caseName, tc.in, err)
+			continue
+		}
+		if !bytes.Equal(out, []byte(tc.expected)) {
+			t.Errorf("case %s for OrgWithOptions() from %s = %s\nwants: %s", caseName, tc.in, out, tc.expected)
+		}
+	}
+}
@@ -68,3 +68,28 @@ func OrgHeaders(input []byte) (map[string]interface{}, error) {
 	return out, nil
 
 }
+
+// OrgDate finds the #+DATE: header of a byte slice of org content and returns its value
+// as written along with the timestamp it holds. A free text date such as "Spring 2023"
+// returns a nil timestamp, and content without a #+DATE: header returns nil and "".
+func OrgDate(input []byte) (*Timestamp, string) {
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		if !bytes.HasPrefix(data, []byte("#+")) {
+			break
+		}
+		matches := reHeader.FindSubmatch(data)
+		if len(matches) < 3 || strings.ToLower(string(matches[1])) != "date" {
+			continue
+		}
+
+		raw := strings.TrimSpace(string(matches[2]))
+		if ts, ok := ParseTimestamp([]byte(raw)); ok {
+			return ts, raw
+		}
+		return nil, raw
+	}
+	return nil, ""
+}
@@ -111,3 +111,42 @@ func TestOrgHeaders(t *testing.T) {
 
 	}
 }
+
+func TestOrgDate(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected *Timestamp
+		raw      string
+	}{
+		"timestamp": {
+			"#+title: a post\n#+DATE: <2023-01-02 Mon 09:30>\n\nSome text.\n",
+			&Timestamp{Active: true, Date: "2023-01-02", StartTime: "09:30"},
+			"<2023-01-02 Mon 09:30>",
+		},
+		"inactive-timestamp": {
+			"#+date: [2023-01-02 Mon]\n",
+			&Timestamp{Date: "2023-01-02"},
+			"[2023-01-02 Mon]",
+		},
+		"free-text": {
+			"#+DATE: Spring 2023\n",
+			nil,
+			"Spring 2023",
+		},
+		"no-date": {
+			"#+title: a post\n\n#+DATE: <2023-01-02 Mon>\n",
+			nil,
+			"",
+		},
+	}
+
+	for caseName, tc := range testCases {
+		ts, raw := OrgDate([]byte(tc.in))
+		if raw != tc.raw {
+			t.Errorf("case %s for OrgDate() raw = %q\nwants: %q", caseName, raw, tc.raw)
+		}
+		if (ts == nil) != (tc.expected == nil) || ts != nil && *ts != *tc.expected {
+			t.Errorf("case %s for OrgDate() = %+v\nwants: %+v", caseName, ts, tc.expected)
+		}
+	}
+}
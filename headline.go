@@ -0,0 +1,124 @@
+package goorgeous
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Headline is a headline of org content along with the parts of its headline line
+type Headline struct {
+	Level int
+	// Status is the TODO keyword of the headline, if it has one
+	Status   string
+	Priority string
+	Title    string
+	Tags     []string
+	// Properties holds the :KEY: value lines of the property drawer directly below
+	// the headline, such as CUSTOM_ID. When a key is repeated the first value is kept.
+	Properties map[string]string
+	// ID is the anchor the headline is rendered with by the default options: its
+	// CUSTOM_ID, or an anchor made from its title with -1, -2 added to repeats
+	ID string
+	// Line is the line of the input the headline is on, counting from 1
+	Line int
+}
+
+// Headlines finds and returns all of the headlines in a byte slice of org content in
+// document order, leaving out lines inside blocks that only look like headlines
+func Headlines(input []byte) []Headline {
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	var headlines []Headline
+	var blocks blockTracker
+	p := &parser{}
+	p.collectScriptOption(input)
+	ids := make(map[string]int)
+
+	for i, data := range lines {
+		if blocks.line(data) || !isHeadline(data) {
+			continue
+		}
+
+		h := parseHeadline(data, nil)
+		id := customID(lines, i+1)
+		if id == "" {
+			id = p.headlineID(h)
+		}
+		headlines = append(headlines, Headline{
+			Level:      h.level,
+			Status:     h.status,
+			Priority:   h.priority,
+			Title:      string(h.title),
+			Tags:       h.tags,
+			Properties: propertyMap(drawerProperties(lines, i+1)),
+			ID:         uniqueID(id, ids),
+			Line:       i + 1,
+		})
+	}
+
+	return headlines
+}
+
+// propertyMap returns props as a map keeping the first value of a repeated key, or
+// nil when there are no properties
+func propertyMap(props [][2]string) map[string]string {
+	if len(props) == 0 {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, prop := range props {
+		if _, ok := m[prop[0]]; !ok {
+			m[prop[0]] = prop[1]
+		}
+	}
+	return m
+}
+
+// uniqueID adds -1, -2 and so on to an id that is already in seen, the way the
+// renderer does for the ids of headlines, and records the id it returns
+func uniqueID(id string, seen map[string]int) string {
+	for count, found := seen[id]; found; count, found = seen[id] {
+		tmp := id + "-" + strconv.Itoa(count+1)
+		if _, tmpFound := seen[tmp]; !tmpFound {
+			seen[id] = count + 1
+			id = tmp
+		} else {
+			id = id + "-1"
+		}
+	}
+	seen[id] = 0
+	return id
+}
+
+// FindHeadline returns the first headline in document order that pred reports true
+// for, or nil when there is none
+func FindHeadline(input []byte, pred func(*Headline) bool) *Headline {
+	headlines := Headlines(input)
+	for i := range headlines {
+		if pred(&headlines[i]) {
+			return &headlines[i]
+		}
+	}
+	return nil
+}
+
+// FindHeadlineByTitle returns the first headline with the given title, trying the
+// title as given before trying it without surrounding whitespace. It returns nil
+// when no headline has the title.
+func FindHeadlineByTitle(input []byte, title string) *Headline {
+	headlines := Headlines(input)
+	for _, want := range []string{title, strings.TrimSpace(title)} {
+		for i := range headlines {
+			if headlines[i].Title == want {
+				return &headlines[i]
+			}
+		}
+	}
+	return nil
+}
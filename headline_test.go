@@ -0,0 +1,147 @@
+package goorgeous
+
+import (
+	"reflect"
+	"testing"
+)
+
+const headlinesIn = `* Inbox :work:
+** DONE Send the report
+** TODO [A] Call back
+#+BEGIN_SRC org
+* TODO not a headline
+#+END_SRC
+* TODO Plan the trip
+`
+
+func TestHeadlines(t *testing.T) {
+	expected := []Headline{
+		{Level: 1, Title: "Inbox", Tags: []string{"work"}, ID: "inbox", Line: 1},
+		{Level: 2, Status: "DONE", Title: "Send the report", ID: "send-the-report", Line: 2},
+		{Level: 2, Status: "TODO", Priority: "A", Title: "Call back", ID: "call-back", Line: 3},
+		{Level: 1, Status: "TODO", Title: "Plan the trip", ID: "plan-the-trip", Line: 7},
+	}
+
+	headlines := Headlines([]byte(headlinesIn))
+	if !reflect.DeepEqual(headlines, expected) {
+		t.Errorf("Headlines() = %+v\nwants: %+v", headlines, expected)
+	}
+}
+
+func TestHeadlinesNestedBlocks(t *testing.T) {
+	expected := []Headline{{Level: 2, Title: "Real", ID: "real", Line: 9}}
+	if headlines := Headlines([]byte(nestedBlockIn)); !reflect.DeepEqual(headlines, expected) {
+		t.Errorf("Headlines() from %s = %+v\nwants: %+v", nestedBlockIn, headlines, expected)
+	}
+}
+
+func TestHeadlinePriorities(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		title    string
+		priority string
+	}{
+		"after-keyword":    {"* TODO [#A] urgent task\n", "urgent task", "A"},
+		"without-keyword":  {"* [#Z] thing\n", "thing", "Z"},
+		"numeric":          {"* [#2] thing\n", "thing", "2"},
+		"short":            {"* TODO [B] thing\n", "thing", "B"},
+		"mid-title":        {"* thing [#A] later\n", "thing [#A] later", ""},
+		"lowercase-letter": {"* [#a] thing\n", "[#a] thing", ""},
+	}
+
+	for caseName, tc := range testCases {
+		headlines := Headlines([]byte(tc.in))
+		if len(headlines) != 1 || headlines[0].Title != tc.title || headlines[0].Priority != tc.priority {
+			t.Errorf("case %s for Headlines() from %q = %+v\nwants: title %q with priority %q", caseName, tc.in, headlines, tc.title, tc.priority)
+		}
+	}
+}
+
+func TestHeadlineProperties(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected map[string]string
+	}{
+		"custom-id":    {"* A\n:PROPERTIES:\n:CUSTOM_ID: intro\n:AUTHOR: me\n:END:\n", map[string]string{"CUSTOM_ID": "intro", "AUTHOR": "me"}},
+		"empty-drawer": {"* A\n:PROPERTIES:\n:END:\n", nil},
+		"duplicate":    {"* A\n:PROPERTIES:\n:ID: first\n:ID: second\n:END:\n", map[string]string{"ID": "first"}},
+		"missing-end":  {"* A\n:PROPERTIES:\n:ID: x\n* B\n:PROPERTIES:\n:ID: y\n:END:\n", nil},
+		"not-directly": {"* A\ntext\n:PROPERTIES:\n:ID: x\n:END:\n", nil},
+	}
+
+	for caseName, tc := range testCases {
+		headlines := Headlines([]byte(tc.in))
+		if len(headlines) == 0 || !reflect.DeepEqual(headlines[0].Properties, tc.expected) {
+			t.Errorf("case %s for Headlines() from %q = %+v\nwants: properties %v", caseName, tc.in, headlines, tc.expected)
+		}
+	}
+}
+
+func TestHeadlineIDs(t *testing.T) {
+	in := "* Notes\n* Notes\n* Setup\n:PROPERTIES:\n:CUSTOM_ID: install\n:END:\n* Notes\n"
+	expected := []string{"notes", "notes-1", "install", "notes-2"}
+
+	var ids []string
+	for _, h := range Headlines([]byte(in)) {
+		ids = append(ids, h.ID)
+	}
+	if !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Headlines() from %q has ids %v\nwants: %v", in, ids, expected)
+	}
+}
+
+func TestHeadlineTags(t *testing.T) {
+	testCases := map[string]struct {
+		in    string
+		title string
+		tags  []string
+	}{
+		"tags":           {"* Meeting notes :work:urgent:\n", "Meeting notes", []string{"work", "urgent"}},
+		"tab-before":     {"* Meeting notes\t:work:\n", "Meeting notes", []string{"work"}},
+		"colon-in-title": {"* Ratio 1 : 2\n", "Ratio 1 : 2", nil},
+		"not-at-end":     {"* a :b: c\n", "a :b: c", nil},
+		"only-tags":      {"* TODO :work:\n", "", []string{"work"}},
+	}
+
+	for caseName, tc := range testCases {
+		headlines := Headlines([]byte(tc.in))
+		if len(headlines) != 1 || headlines[0].Title != tc.title || !reflect.DeepEqual(headlines[0].Tags, tc.tags) {
+			t.Errorf("case %s for Headlines() from %q = %+v\nwants: title %q with tags %v", caseName, tc.in, headlines, tc.title, tc.tags)
+		}
+	}
+}
+
+func TestFindHeadline(t *testing.T) {
+	firstTodo := FindHeadline([]byte(headlinesIn), func(h *Headline) bool {
+		return h.Status == "TODO"
+	})
+	if firstTodo == nil || firstTodo.Title != "Call back" {
+		t.Errorf("FindHeadline() for the first TODO = %+v\nwants: the Call back headline", firstTodo)
+	}
+
+	if h := FindHeadline([]byte(headlinesIn), func(h *Headline) bool { return h.Level > 2 }); h != nil {
+		t.Errorf("FindHeadline() for a level 3 headline = %+v\nwants: nil", h)
+	}
+}
+
+func TestFindHeadlineByTitle(t *testing.T) {
+	testCases := map[string]struct {
+		title    string
+		expected int
+	}{
+		"exact":     {"Plan the trip", 7},
+		"trimmed":   {"  Send the report ", 2},
+		"not-found": {"not a headline", 0},
+	}
+
+	for caseName, tc := range testCases {
+		h := FindHeadlineByTitle([]byte(headlinesIn), tc.title)
+		line := 0
+		if h != nil {
+			line = h.Line
+		}
+		if line != tc.expected {
+			t.Errorf("case %s for FindHeadlineByTitle(%q) found line %d\nwants: %d", caseName, tc.title, line, tc.expected)
+		}
+	}
+}
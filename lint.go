@@ -0,0 +1,251 @@
+package goorgeous
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/russross/blackfriday"
+)
+
+// Diagnostic is a likely mistake found in org content by Lint
+type Diagnostic struct {
+	// Line and Column are where the mistake is, counting from 1. Column is 0 when
+	// the mistake is the whole line.
+	Line   int
+	Column int
+	// Rule is the name of the check that found the mistake, such as empty-headline
+	Rule string
+	Msg  string
+}
+
+func (d Diagnostic) String() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("line %d: %s: %s", d.Line, d.Rule, d.Msg)
+	}
+	return fmt.Sprintf("line %d:%d: %s: %s", d.Line, d.Column, d.Rule, d.Msg)
+}
+
+// lintRule is one of the checks of Lint. lines holds every line of the content and
+// inBlock reports which of them are inside a block.
+type lintRule struct {
+	name  string
+	check func(lines [][]byte, inBlock []bool) []Diagnostic
+}
+
+var lintRules = []lintRule{
+	{"structure", lintStructure},
+	{"unclosed-emphasis", lintEmphasis},
+	{"empty-link-path", lintEmptyLinks},
+	{"duplicate-custom-id", lintCustomIDs},
+	{"empty-headline", lintEmptyHeadlines},
+}
+
+// Lint checks a byte slice of org content for likely mistakes and returns them in the
+// order they appear. The structure rule reports the problems OrgWithOptions finds,
+// such as blocks and drawers that are never closed; the other rules look for
+// emphasis without its closing marker, links with an empty path, CUSTOM_IDs used by
+// more than one headline and headlines without a title.
+func Lint(input []byte) []Diagnostic {
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	inBlock := make([]bool, len(lines))
+	var blocks blockTracker
+	for i, data := range lines {
+		inBlock[i] = blocks.line(data)
+	}
+
+	var diagnostics []Diagnostic
+	for _, rule := range lintRules {
+		for _, d := range rule.check(lines, inBlock) {
+			d.Rule = rule.name
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	sort.Stable(diagnosticsByPosition(diagnostics))
+	return diagnostics
+}
+
+// diagnosticsByPosition sorts diagnostics by the line and column they are found at
+type diagnosticsByPosition []Diagnostic
+
+func (d diagnosticsByPosition) Len() int      { return len(d) }
+func (d diagnosticsByPosition) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d diagnosticsByPosition) Less(i, j int) bool {
+	if d[i].Line != d[j].Line {
+		return d[i].Line < d[j].Line
+	}
+	return d[i].Column < d[j].Column
+}
+
+// lintStructure reports the problems found while rendering the content
+func lintStructure(lines [][]byte, inBlock []bool) []Diagnostic {
+	input := bytes.Join(lines, []byte("\n"))
+	_, err := OrgWithOptions(input, blackfriday.HtmlRenderer(0, "", ""), DefaultOptions())
+
+	var diagnostics []Diagnostic
+	if multiErr, ok := err.(*MultiError); ok {
+		for _, parseErr := range multiErr.Errors {
+			diagnostics = append(diagnostics, Diagnostic{Line: parseErr.Line, Msg: parseErr.Msg})
+		}
+	}
+	return diagnostics
+}
+
+var reEmptyHeadline = regexp.MustCompile(`^\*+[ \t]+$`)
+
+// lintEmptyHeadlines reports headlines with nothing but whitespace after their stars
+func lintEmptyHeadlines(lines [][]byte, inBlock []bool) []Diagnostic {
+	var diagnostics []Diagnostic
+	for i, data := range lines {
+		if !inBlock[i] && reEmptyHeadline.Match(data) {
+			diagnostics = append(diagnostics, Diagnostic{Line: i + 1, Msg: "headline has no title"})
+		}
+	}
+	return diagnostics
+}
+
+var reEmptyLink = regexp.MustCompile(`\[\[[ \t]*\](?:\[[^\]]*\])?\]`)
+
+// lintEmptyLinks reports links such as [[]] or [[][description]] that have no path
+func lintEmptyLinks(lines [][]byte, inBlock []bool) []Diagnostic {
+	var diagnostics []Diagnostic
+	for i, data := range lines {
+		if inBlock[i] || isExampleLine(data) {
+			continue
+		}
+		for _, loc := range reEmptyLink.FindAllIndex(data, -1) {
+			diagnostics = append(diagnostics, Diagnostic{Line: i + 1, Column: loc[0] + 1, Msg: "link has an empty path"})
+		}
+	}
+	return diagnostics
+}
+
+// lintCustomIDs reports every headline whose CUSTOM_ID was already given to a
+// headline above it
+func lintCustomIDs(lines [][]byte, inBlock []bool) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := make(map[string]int)
+	for i, data := range lines {
+		if inBlock[i] || !isHeadline(data) {
+			continue
+		}
+		id := customID(lines, i+1)
+		if id == "" {
+			continue
+		}
+		if line, ok := seen[id]; ok {
+			diagnostics = append(diagnostics, Diagnostic{Line: i + 1, Msg: fmt.Sprintf("CUSTOM_ID %q is already used by the headline on line %d", id, line)})
+			continue
+		}
+		seen[id] = i + 1
+	}
+	return diagnostics
+}
+
+var reLinkSpan = regexp.MustCompile(`\[\[.*?\]\]`)
+
+// lintEmphasis reports emphasis markers such as the * of *bold that open a span
+// which is not closed before the end of its paragraph
+func lintEmphasis(lines [][]byte, inBlock []bool) []Diagnostic {
+	var diagnostics []Diagnostic
+	for i, data := range lines {
+		if inBlock[i] || !lintsEmphasis(data) {
+			continue
+		}
+		// the rest of the paragraph or list item, which a span may be closed in
+		var rest [][]byte
+		for j := i + 1; j < len(lines) && !inBlock[j] && !isEmpty(lines[j]) && !isHeadline(lines[j]); j++ {
+			if endsListItem(data, lines[j]) {
+				break
+			}
+			rest = append(rest, lines[j])
+		}
+
+		text := blankLinks(data)
+		start := 0
+		if isHeadline(text) {
+			start = headlineLevel(text)
+		}
+		for k := start; k < len(text); k++ {
+			if !opensEmphasis(text, k) {
+				continue
+			}
+			if end := closesEmphasis(text, k); end > 0 {
+				// markers inside the span, such as in =a*b=, are its text
+				k = end
+				continue
+			}
+			if !closedIn(rest, text[k]) {
+				diagnostics = append(diagnostics, Diagnostic{Line: i + 1, Column: k + 1, Msg: fmt.Sprintf("%c opens emphasis that is never closed", text[k])})
+			}
+		}
+	}
+	return diagnostics
+}
+
+// lintsEmphasis reports whether a line is text that can hold emphasis
+func lintsEmphasis(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return !(isEmpty(data) || isExampleLine(data) || IsKeyword(data) || isComment(data) ||
+		isDrawer(data) || isHorizontalRule(data) || reTableHeaders.Match(trimmed))
+}
+
+// blankLinks replaces the links of a line with spaces, keeping the columns of the rest
+func blankLinks(data []byte) []byte {
+	return reLinkSpan.ReplaceAllFunc(data, func(link []byte) []byte {
+		return bytes.Repeat([]byte(" "), len(link))
+	})
+}
+
+// endsListItem reports whether next starts a list item, or is a line that does not
+// continue the list item started by data, so that a span opened in data cannot be
+// closed on it
+func endsListItem(data, next []byte) bool {
+	if isUnorderedList(next) || isOrderedList(next) {
+		return true
+	}
+	if !isUnorderedList(data) && !isOrderedList(data) {
+		return false
+	}
+	p := &parser{}
+	return p.indentColumn(next) <= p.indentColumn(data)
+}
+
+// opensEmphasis reports whether the marker at i can open a span, by the same rules
+// the renderer follows, and is not followed by a second marker as in **
+func opensEmphasis(data []byte, i int) bool {
+	if !isMarkupChar(data[i]) || i+1 >= len(data) || isSpace(data[i+1]) || data[i+1] == data[i] {
+		return false
+	}
+	return isAcceptablePreOpeningChar(data, data[i:], i)
+}
+
+// closesEmphasis returns the index of the marker closing the span opened at start
+// on the same line, or -1 when there is none
+func closesEmphasis(data []byte, start int) int {
+	for i := start + 2; i < len(data); i++ {
+		if data[i] == data[start] && isAcceptableClosingMarker(data, i) {
+			return i
+		}
+	}
+	return -1
+}
+
+// closedIn reports whether marker closes a span on any of the lines
+func closedIn(lines [][]byte, marker byte) bool {
+	for _, data := range lines {
+		for i := range data {
+			if data[i] == marker && isAcceptableClosingMarker(data, i) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package goorgeous
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintRules(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected []Diagnostic
+	}{
+		"unterminated-block": {
+			"Text.\n#+BEGIN_SRC sh\necho a\n",
+			[]Diagnostic{{Line: 2, Rule: "structure", Msg: "#+BEGIN_SRC has no #+END_SRC"}},
+		},
+		"unclosed-emphasis": {
+			"Some *bold text.\nMore /fine/ text and =a*b= too.\n\n- an *item\n  closed* later\n",
+			[]Diagnostic{{Line: 1, Column: 6, Rule: "unclosed-emphasis", Msg: "* opens emphasis that is never closed"}},
+		},
+		"unclosed-emphasis-in-list-item": {
+			"- an *item\n- the next* item\n\n- a /third\nnot in the item/\n",
+			[]Diagnostic{
+				{Line: 1, Column: 6, Rule: "unclosed-emphasis", Msg: "* opens emphasis that is never closed"},
+				{Line: 4, Column: 5, Rule: "unclosed-emphasis", Msg: "/ opens emphasis that is never closed"},
+			},
+		},
+		"empty-link-path": {
+			"See [[][nothing]] and [[https://example.com/a_b]] or [[]].\n",
+			[]Diagnostic{
+				{Line: 1, Column: 5, Rule: "empty-link-path", Msg: "link has an empty path"},
+				{Line: 1, Column: 54, Rule: "empty-link-path", Msg: "link has an empty path"},
+			},
+		},
+		"duplicate-custom-id": {
+			"* One\n:PROPERTIES:\n:CUSTOM_ID: one\n:END:\n* Two\n:PROPERTIES:\n:CUSTOM_ID: one\n:END:\n",
+			[]Diagnostic{{Line: 5, Rule: "duplicate-custom-id", Msg: "CUSTOM_ID \"one\" is already used by the headline on line 1"}},
+		},
+		"empty-headline": {
+			"* \n** Title\n",
+			[]Diagnostic{{Line: 1, Rule: "empty-headline", Msg: "headline has no title"}},
+		},
+		"inside-nested-block": {
+			nestedBlockIn,
+			nil,
+		},
+		"inside-block": {
+			"#+BEGIN_EXAMPLE\n* \n*open [[]]\n#+END_EXAMPLE\n",
+			nil,
+		},
+	}
+
+	for caseName, tc := range testCases {
+		diagnostics := Lint([]byte(tc.in))
+		if !reflect.DeepEqual(diagnostics, tc.expected) {
+			t.Errorf("case %s for Lint() from %s = %v\nwants: %v", caseName, tc.in, diagnostics, tc.expected)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	in := "* \nSome *bold text.\n\n#+BEGIN_QUOTE\nquoted\n"
+	expected := []string{
+		"line 1: empty-headline: headline has no title",
+		"line 2:6: unclosed-emphasis: * opens emphasis that is never closed",
+		"line 4: structure: #+BEGIN_QUOTE has no #+END_QUOTE",
+	}
+
+	diagnostics := Lint([]byte(in))
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("Lint() from %s = %v\nwants: %v", in, diagnostics, expected)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expected[i] {
+			t.Errorf("Lint() from %s diagnostic %d = %s\nwants: %s", in, i, d, expected[i])
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package goorgeous
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// SrcBlock is a #+BEGIN_SRC block along with the metadata found on its header line
+type SrcBlock struct {
+	// Name is set from a #+NAME: line directly above the block
+	Name string
+	Lang string
+	// Switches holds the flags that follow the language, such as -n or -r
+	Switches []string
+	// Args holds the :key value header arguments, including ones missing from
+	// KnownSrcArgs; repeated :var arguments are collected into Vars instead
+	Args map[string]string
+	// Vars holds each :var binding with its value kept as the raw string
+	Vars map[string]string
+	// Header is the text after #+BEGIN_SRC as written, keeping the order of the
+	// switches and arguments
+	Header string
+	Body   []byte
+}
+
+// rawBlocks are the blocks whose content is kept as text, so a #+BEGIN_SRC inside
+// one of them is not the start of a source block
+var rawBlocks = map[string]bool{"EXAMPLE": true, "VERSE": true, "EXPORT": true, "HTML": true, "COMMENT": true}
+
+// SrcBlocks finds and returns all of the source blocks in a byte slice of org content.
+// Source blocks in quote and other greater blocks are found, but not the #+BEGIN_SRC
+// lines inside the rawBlocks, such as an example block.
+func SrcBlocks(input []byte) []SrcBlock {
+	var blocks []SrcBlock
+	var cur *SrcBlock
+	var body bytes.Buffer
+	var name string
+	// raw is the name of the raw block being skipped
+	raw := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		matches := findBlock(data)
+
+		if raw != "" {
+			if len(matches) > 0 && string(matches[1]) == "END" && string(matches[2]) == raw {
+				raw = ""
+			}
+			continue
+		}
+
+		if cur == nil {
+			if len(matches) > 0 && string(matches[1]) == "BEGIN" && string(matches[2]) == "SRC" {
+				block := parseSrcHeader(blockHeader(data))
+				block.Name = name
+				cur = &block
+				body.Reset()
+			} else if len(matches) > 0 && string(matches[1]) == "BEGIN" && rawBlocks[string(matches[2])] {
+				raw = string(matches[2])
+			}
+			name = ""
+			if nameMatches := reName.FindSubmatch(data); nameMatches != nil {
+				name = string(nameMatches[1])
+			}
+			continue
+		}
+
+		if len(matches) > 0 && string(matches[1]) == "END" && string(matches[2]) == "SRC" {
+			cur.Body = append([]byte(nil), body.Bytes()...)
+			blocks = append(blocks, *cur)
+			cur = nil
+			continue
+		}
+		body.Write(data)
+		body.WriteByte('\n')
+	}
+
+	return blocks
+}
+
+// Cache reports whether the block has :cache yes, asking babel to reuse its previous
+// results instead of evaluating it again
+func (b SrcBlock) Cache() bool {
+	return b.Args["cache"] == "yes"
+}
+
+// Dir returns the :dir header argument, the directory the block is evaluated in and
+// that the relative file paths of its results are resolved against
+func (b SrcBlock) Dir() string {
+	return strings.Trim(b.Args["dir"], "\"")
+}
+
+// KnownSrcArgs is the set of header arguments org babel understands. It is only used
+// to tell which arguments of a block are unknown; Args keeps every argument either way.
+var KnownSrcArgs = map[string]bool{
+	"cache": true, "cmdline": true, "colnames": true, "comments": true, "dir": true,
+	"epilogue": true, "eval": true, "exports": true, "file": true, "file-desc": true,
+	"file-ext": true, "hlines": true, "mkdirp": true, "no-expand": true, "noweb": true,
+	"noweb-ref": true, "noweb-sep": true, "output-dir": true, "padline": true,
+	"post": true, "prologue": true, "results": true, "rownames": true, "sep": true,
+	"session": true, "shebang": true, "tangle": true, "tangle-mode": true, "var": true,
+	"wrap": true,
+}
+
+// UnknownArgs returns the sorted keys of the block's header arguments that are not in
+// KnownSrcArgs, such as vendor specific arguments for other tools
+func (b SrcBlock) UnknownArgs() []string {
+	var keys []string
+	for key := range b.Args {
+		if !KnownSrcArgs[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// hasResults reports whether param, such as silent or output, is one of the :results arguments
+func (b SrcBlock) hasResults(param string) bool {
+	return containsString(strings.Fields(b.Args["results"]), param)
+}
+
+var reName = regexp.MustCompile(`(?i)^\s*#\+NAME:\s*(\S+)`)
+
+// CodeBlocks returns the source blocks of a byte slice of org content that are in the
+// language lang, in document order. An empty lang returns every source block.
+func CodeBlocks(input []byte, lang string) []SrcBlock {
+	var blocks []SrcBlock
+	for _, block := range SrcBlocks(input) {
+		if lang == "" || block.Lang == lang {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// parseSrcHeader parses what follows #+BEGIN_SRC: a language, switches and header arguments
+func parseSrcHeader(header string) SrcBlock {
+	block := SrcBlock{
+		Args:   make(map[string]string),
+		Vars:   make(map[string]string),
+		Header: strings.TrimSpace(header),
+	}
+
+	fields := splitHeaderFields(header)
+	i := 0
+	if i < len(fields) && !strings.HasPrefix(fields[i], ":") && !strings.HasPrefix(fields[i], "-") {
+		block.Lang = fields[i]
+		i++
+	}
+	for i < len(fields) && strings.HasPrefix(fields[i], "-") {
+		block.Switches = append(block.Switches, fields[i])
+		i++
+	}
+
+	for i < len(fields) {
+		key := fields[i]
+		i++
+		if !strings.HasPrefix(key, ":") {
+			continue
+		}
+		start := i
+		for i < len(fields) && !strings.HasPrefix(fields[i], ":") {
+			i++
+		}
+		val := strings.Join(fields[start:i], " ")
+
+		if key == ":var" {
+			for _, assignment := range splitVarAssignments(val) {
+				eq := strings.Index(assignment, "=")
+				if eq < 0 {
+					continue
+				}
+				block.Vars[strings.TrimSpace(assignment[:eq])] = strings.TrimSpace(assignment[eq+1:])
+			}
+			continue
+		}
+		block.Args[key[1:]] = val
+	}
+
+	return block
+}
+
+// splitHeaderFields splits a header line on whitespace that is outside of
+// double quotes and parentheses so values like "a b" or (+ 1 2) stay whole
+func splitHeaderFields(header string) []string {
+	var fields []string
+	var field bytes.Buffer
+	inQuote := false
+	depth := 0
+
+	for _, r := range header {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case r == '(' && !inQuote:
+			depth++
+		case r == ')' && !inQuote && depth > 0:
+			depth--
+		case (r == ' ' || r == '\t') && !inQuote && depth == 0:
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+			continue
+		}
+		field.WriteRune(r)
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+// splitVarAssignments splits the value of a :var argument on the commas that
+// separate several name=value assignments
+func splitVarAssignments(val string) []string {
+	var assignments []string
+	inQuote := false
+	depth := 0
+	start := 0
+
+	for i, r := range val {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+		case (r == '(' || r == '[') && !inQuote:
+			depth++
+		case (r == ')' || r == ']') && !inQuote && depth > 0:
+			depth--
+		case r == ',' && !inQuote && depth == 0:
+			assignments = append(assignments, val[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(assignments, val[start:])
+}
+
+var reNowebRef = regexp.MustCompile(`^(\s*)<<([^<>\s]+)>>\s*$`)
+
+// tangleExtensions are the file extensions of the languages whose extension is not
+// the name of the language itself, for blocks with :tangle yes
+var tangleExtensions = map[string]string{
+	"C": "c", "C++": "cpp", "clojure": "clj", "elisp": "el", "emacs-lisp": "el",
+	"haskell": "hs", "javascript": "js", "latex": "tex", "perl": "pl", "python": "py",
+	"ruby": "rb", "bash": "sh", "shell": "sh", "zsh": "sh",
+}
+
+// Tangle collects the bodies of the source blocks with a :tangle header argument and
+// returns them keyed by their target file, concatenated in document order. Blocks with
+// :tangle no, or without :tangle, are skipped. As in org, a block with :tangle yes goes
+// to basename, the name of the org file without its extension, followed by the
+// extension of the block's language, such as notes.py; those blocks are skipped when
+// basename is empty. In blocks with :noweb yes or :noweb tangle, a line holding only
+// <<name>> is replaced with the body of the block named name.
+func Tangle(input []byte, basename string) map[string][]byte {
+	blocks := SrcBlocks(input)
+
+	named := make(map[string]SrcBlock)
+	for _, block := range blocks {
+		if _, ok := named[block.Name]; block.Name != "" && !ok {
+			named[block.Name] = block
+		}
+	}
+
+	files := make(map[string][]byte)
+	for _, block := range blocks {
+		target := block.Args["tangle"]
+		if target == "" || target == "no" {
+			continue
+		}
+		if target == "yes" {
+			if basename == "" || block.Lang == "" {
+				continue
+			}
+			ext, ok := tangleExtensions[block.Lang]
+			if !ok {
+				ext = block.Lang
+			}
+			target = basename + "." + ext
+		}
+		target = strings.Trim(target, "\"")
+		files[target] = append(files[target], expandNoweb(block, named, nil)...)
+	}
+
+	return files
+}
+
+// expandNoweb returns the body of a block with its <<name>> references expanded when
+// the block has noweb enabled. seen guards against blocks that reference themselves.
+func expandNoweb(block SrcBlock, named map[string]SrcBlock, seen []string) []byte {
+	if noweb := block.Args["noweb"]; noweb != "yes" && noweb != "tangle" {
+		return block.Body
+	}
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(block.Body))
+	for scanner.Scan() {
+		data := scanner.Bytes()
+		matches := reNowebRef.FindSubmatch(data)
+		if matches == nil {
+			buf.Write(data)
+			buf.WriteByte('\n')
+			continue
+		}
+
+		ref, ok := named[string(matches[2])]
+		if !ok || containsString(seen, ref.Name) {
+			continue
+		}
+		// every line of the referenced body keeps the indentation of the reference
+		body := expandNoweb(ref, named, append(seen, block.Name, ref.Name))
+		for _, line := range bytes.SplitAfter(body, []byte("\n")) {
+			if len(line) > 0 {
+				buf.Write(matches[1])
+				buf.Write(line)
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,247 @@
+package goorgeous
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSrcBlocksVars(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected map[string]string
+	}{
+		"no-vars": {
+			"#+BEGIN_SRC sh\necho \"foo\"\n#+END_SRC\n",
+			map[string]string{},
+		},
+		"multiple-vars": {
+			"#+BEGIN_SRC python :var x=1 :var data=table :results output\nprint(x)\n#+END_SRC\n",
+			map[string]string{"x": "1", "data": "table"},
+		},
+		"comma-separated-vars": {
+			"#+BEGIN_SRC python :var x=1, y=\"a string\"\nprint(x, y)\n#+END_SRC\n",
+			map[string]string{"x": "1", "y": "\"a string\""},
+		},
+		"var-value-forms": {
+			"#+BEGIN_SRC emacs-lisp :var n=(+ 1 2) :var cell=tbl[1,2] :var other=other-block()\n(list n cell other)\n#+END_SRC\n",
+			map[string]string{"n": "(+ 1 2)", "cell": "tbl[1,2]", "other": "other-block()"},
+		},
+	}
+
+	for caseName, tc := range testCases {
+		blocks := SrcBlocks([]byte(tc.in))
+		if len(blocks) != 1 {
+			t.Fatalf("case %s for SrcBlocks() from %s found %d blocks\nwants: 1", caseName, tc.in, len(blocks))
+		}
+		if !reflect.DeepEqual(blocks[0].Vars, tc.expected) {
+			t.Errorf("case %s for SrcBlocks() from %s = %v\nwants: %v", caseName, tc.in, blocks[0].Vars, tc.expected)
+		}
+	}
+}
+
+func TestSrcBlocks(t *testing.T) {
+	in := "Some text.\n\n#+BEGIN_SRC go -n :results output\nfmt.Println(\"foo\")\n#+END_SRC\n\n#+BEGIN_SRC\nno language\n#+END_SRC\n"
+	expected := []SrcBlock{
+		{
+			Lang:     "go",
+			Switches: []string{"-n"},
+			Args:     map[string]string{"results": "output"},
+			Vars:     map[string]string{},
+			Header:   "go -n :results output",
+			Body:     []byte("fmt.Println(\"foo\")\n"),
+		},
+		{
+			Args: map[string]string{},
+			Vars: map[string]string{},
+			Body: []byte("no language\n"),
+		},
+	}
+
+	blocks := SrcBlocks([]byte(in))
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("SrcBlocks() from %s = %#v\nwants: %#v", in, blocks, expected)
+	}
+}
+
+func TestSrcBlocksUnknownArgs(t *testing.T) {
+	in := "#+BEGIN_SRC sh :myarg foo :results output :vendor-x a b\necho \"foo\"\n#+END_SRC\n"
+	blocks := SrcBlocks([]byte(in))
+	if len(blocks) != 1 {
+		t.Fatalf("SrcBlocks() from %s found %d blocks\nwants: 1", in, len(blocks))
+	}
+	block := blocks[0]
+
+	expected := map[string]string{"myarg": "foo", "results": "output", "vendor-x": "a b"}
+	if !reflect.DeepEqual(block.Args, expected) {
+		t.Errorf("SrcBlocks() from %s Args = %v\nwants: %v", in, block.Args, expected)
+	}
+	if unknown := block.UnknownArgs(); !reflect.DeepEqual(unknown, []string{"myarg", "vendor-x"}) {
+		t.Errorf("UnknownArgs() from %s = %v\nwants: [myarg vendor-x]", in, unknown)
+	}
+	if header := "sh :myarg foo :results output :vendor-x a b"; block.Header != header {
+		t.Errorf("SrcBlocks() from %s Header = %q\nwants: %q", in, block.Header, header)
+	}
+}
+
+func TestCodeBlocks(t *testing.T) {
+	in := `#+BEGIN_SRC python
+print("one")
+#+END_SRC
+
+- a list item
+  #+BEGIN_SRC python :results output
+  print("two")
+  #+END_SRC
+
+#+BEGIN_QUOTE
+#+BEGIN_SRC sh
+echo three
+#+END_SRC
+#+END_QUOTE
+
+#+BEGIN_EXAMPLE
+#+BEGIN_SRC python
+print("in an example")
+#+END_SRC
+#+END_EXAMPLE
+
+#+BEGIN_VERSE
+#+BEGIN_SRC sh
+echo in a verse
+#+END_SRC
+#+END_VERSE
+
+: #+BEGIN_SRC sh
+: echo fixed width
+: #+END_SRC
+`
+
+	testCases := map[string]struct {
+		lang     string
+		expected []string
+	}{
+		"python":  {"python", []string{"print(\"one\")\n", "  print(\"two\")\n"}},
+		"all":     {"", []string{"print(\"one\")\n", "  print(\"two\")\n", "echo three\n"}},
+		"missing": {"go", nil},
+	}
+
+	for caseName, tc := range testCases {
+		var bodies []string
+		for _, block := range CodeBlocks([]byte(in), tc.lang) {
+			bodies = append(bodies, string(block.Body))
+		}
+		if !reflect.DeepEqual(bodies, tc.expected) {
+			t.Errorf("case %s for CodeBlocks(%q) found bodies %q\nwants: %q", caseName, tc.lang, bodies, tc.expected)
+		}
+	}
+}
+
+func TestSrcBlockHeader(t *testing.T) {
+	header := `python -r -n :var b=2 :results output :exports both -l "(ref:%s)"`
+	blocks := SrcBlocks([]byte("#+BEGIN_SRC " + header + "  \nprint(b)\n#+END_SRC\n"))
+	if len(blocks) != 1 {
+		t.Fatalf("SrcBlocks() found %d blocks\nwants: 1", len(blocks))
+	}
+	if blocks[0].Header != header {
+		t.Errorf("SrcBlocks() Header = %q\nwants: %q", blocks[0].Header, header)
+	}
+}
+
+func TestSrcBlockCache(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected bool
+	}{
+		"cache-yes": {"#+BEGIN_SRC sh :cache yes\ndate\n#+END_SRC\n", true},
+		"cache-no":  {"#+BEGIN_SRC sh :cache no\ndate\n#+END_SRC\n", false},
+		"no-cache":  {"#+BEGIN_SRC sh\ndate\n#+END_SRC\n", false},
+	}
+
+	for caseName, tc := range testCases {
+		blocks := SrcBlocks([]byte(tc.in))
+		if len(blocks) != 1 {
+			t.Fatalf("case %s for SrcBlocks() from %s found %d blocks\nwants: 1", caseName, tc.in, len(blocks))
+		}
+		if cache := blocks[0].Cache(); cache != tc.expected {
+			t.Errorf("case %s for Cache() from %s = %t\nwants: %t", caseName, tc.in, cache, tc.expected)
+		}
+	}
+}
+
+func TestSrcBlockDir(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		expected string
+	}{
+		"dir":        {"#+BEGIN_SRC sh :dir /tmp\nls\n#+END_SRC\n", "/tmp"},
+		"quoted-dir": {"#+BEGIN_SRC sh :dir \"out dir\" :results file\nls\n#+END_SRC\n", "out dir"},
+		"no-dir":     {"#+BEGIN_SRC sh\nls\n#+END_SRC\n", ""},
+	}
+
+	for caseName, tc := range testCases {
+		blocks := SrcBlocks([]byte(tc.in))
+		if len(blocks) != 1 {
+			t.Fatalf("case %s for SrcBlocks() from %s found %d blocks\nwants: 1", caseName, tc.in, len(blocks))
+		}
+		if dir := blocks[0].Dir(); dir != tc.expected {
+			t.Errorf("case %s for Dir() from %s = %q\nwants: %q", caseName, tc.in, dir, tc.expected)
+		}
+	}
+}
+
+func TestTangle(t *testing.T) {
+	in := `* Setup
+#+BEGIN_SRC sh :tangle setup.sh
+echo "one"
+#+END_SRC
+
+Not tangled:
+#+BEGIN_SRC sh :tangle no
+echo "skipped"
+#+END_SRC
+
+#+BEGIN_SRC sh
+echo "no tangle argument"
+#+END_SRC
+
+#+BEGIN_SRC go :tangle main.go :noweb yes
+func main() {
+	<<greeting>>
+}
+#+END_SRC
+
+#+NAME: greeting
+#+BEGIN_SRC go
+fmt.Println("hello")
+#+END_SRC
+
+#+BEGIN_SRC sh :tangle setup.sh
+echo "two"
+#+END_SRC
+
+#+BEGIN_SRC python :tangle yes
+print("three")
+#+END_SRC
+
+#+BEGIN_EXAMPLE
+#+BEGIN_SRC sh :tangle setup.sh
+echo "in an example"
+#+END_SRC
+#+END_EXAMPLE
+`
+	expected := map[string][]byte{
+		"setup.sh": []byte("echo \"one\"\necho \"two\"\n"),
+		"main.go":  []byte("func main() {\n\tfmt.Println(\"hello\")\n}\n"),
+		"notes.py": []byte("print(\"three\")\n"),
+	}
+
+	files := Tangle([]byte(in), "notes")
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("Tangle() = %q\nwants: %q", files, expected)
+	}
+
+	delete(expected, "notes.py")
+	if files := Tangle([]byte(in), ""); !reflect.DeepEqual(files, expected) {
+		t.Errorf("Tangle() without a basename = %q\nwants: %q", files, expected)
+	}
+}
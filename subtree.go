@@ -0,0 +1,210 @@
+package goorgeous
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+type outlineEntry struct {
+	level int
+	title string
+	tags  []string
+	props [][2]string
+}
+
+// Subtree finds the headline at the given outline path, such as
+// []string{"Chapter 1", "Section A"}, and returns its subtree as org content of its own
+// with that headline promoted to the top level. The #+ keywords at the start of the
+// input are kept, and the tags and properties of its ancestors, except CUSTOM_ID and
+// ID, are carried down onto the promoted headline. ok is false when no headline
+// matches the path.
+func Subtree(input []byte, path []string) (out []byte, ok bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	for scanner.Scan() {
+		lines = append(lines, append([]byte(nil), scanner.Bytes()...))
+	}
+
+	var stack []outlineEntry
+	root, end := -1, len(lines)
+	var blocks blockTracker
+
+	for i, data := range lines {
+		if blocks.line(data) || !isHeadline(data) {
+			continue
+		}
+
+		h := parseHeadline(data, nil)
+		if root >= 0 {
+			if h.level <= stack[len(stack)-1].level {
+				end = i
+				break
+			}
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].level >= h.level {
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, outlineEntry{h.level, string(h.title), h.tags, drawerProperties(lines, i+1)})
+
+		if outlineMatches(stack, path) {
+			root = i
+		}
+	}
+
+	if root < 0 {
+		return nil, false
+	}
+
+	var buf bytes.Buffer
+	for _, data := range lines {
+		// a block opening the content is not one of its keywords
+		if !IsKeyword(data) || isBlock(data) {
+			break
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	ancestors := stack[:len(stack)-1]
+	shift := stack[len(stack)-1].level - 1
+
+	buf.Write(promotedHeadline(lines[root], shift, ancestors))
+	buf.WriteByte('\n')
+
+	body := lines[root+1 : end]
+	if inherited := inheritedProperties(ancestors, drawerProperties(lines, root+1)); len(inherited) > 0 {
+		buf.WriteString(":PROPERTIES:\n")
+		if len(body) > 0 && isPropertyDrawer(body[0]) {
+			// the headline's own properties come first, followed by the inherited ones
+			body = body[1:]
+			for len(body) > 0 && !bytes.Equal(body[0], []byte(":END:")) {
+				buf.Write(body[0])
+				buf.WriteByte('\n')
+				body = body[1:]
+			}
+			if len(body) > 0 {
+				body = body[1:]
+			}
+		}
+		for _, prop := range inherited {
+			buf.WriteString(":" + prop[0] + ": " + prop[1] + "\n")
+		}
+		buf.WriteString(":END:\n")
+	}
+
+	blocks = blockTracker{}
+	for _, data := range body {
+		if !blocks.line(data) && isHeadline(data) {
+			data = data[shift:]
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), true
+}
+
+func outlineMatches(stack []outlineEntry, path []string) bool {
+	if len(stack) != len(path) {
+		return false
+	}
+	for i := range stack {
+		if stack[i].title != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// promotedHeadline removes shift levels from a headline line and adds the tags of
+// its ancestors after its own
+func promotedHeadline(data []byte, shift int, ancestors []outlineEntry) []byte {
+	data = data[shift:]
+
+	h := parseHeadline(data, nil)
+	tags := append([]string(nil), h.tags...)
+	for _, ancestor := range ancestors {
+		for _, tag := range ancestor.tags {
+			if !containsString(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) == len(h.tags) {
+		return data
+	}
+
+	if _, tagsFound := findTags(data, 0); tagsFound > 0 {
+		data = data[:tagsFound]
+	}
+	line := append([]byte(nil), bytes.TrimRight(data, " \t")...)
+	return append(line, []byte(" :"+strings.Join(tags, ":")+":")...)
+}
+
+// drawerProperties returns the :KEY: value lines of a property drawer starting at line
+// start. A drawer that reaches the next headline or the end of the input without its
+// :END: has no properties.
+func drawerProperties(lines [][]byte, start int) [][2]string {
+	if start >= len(lines) || !isPropertyDrawer(lines[start]) {
+		return nil
+	}
+
+	var props [][2]string
+	for _, data := range lines[start+1:] {
+		if bytes.Equal(data, []byte(":END:")) {
+			return props
+		}
+		if isHeadline(data) {
+			break
+		}
+		matches := reProperty.FindSubmatch(data)
+		if matches != nil {
+			props = append(props, [2]string{string(matches[1]), string(matches[2])})
+		}
+	}
+	return nil
+}
+
+// identityProperties name a single headline, so the promoted headline never takes
+// them from its ancestors
+var identityProperties = []string{"CUSTOM_ID", "ID"}
+
+// inheritedProperties returns the properties of the ancestors that own does not set,
+// with the nearest ancestor winning when several set the same key. The
+// identityProperties are left out.
+func inheritedProperties(ancestors []outlineEntry, own [][2]string) [][2]string {
+	seen := make(map[string]bool)
+	for _, key := range identityProperties {
+		seen[key] = true
+	}
+	for _, prop := range own {
+		seen[strings.ToUpper(prop[0])] = true
+	}
+
+	var props [][2]string
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		for _, prop := range ancestors[i].props {
+			if key := strings.ToUpper(prop[0]); !seen[key] {
+				seen[key] = true
+				props = append(props, prop)
+			}
+		}
+	}
+	return props
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package goorgeous
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSubtree(t *testing.T) {
+	in := "#+TITLE: a book\n#+FILETAGS: :book:\n\nIntro.\n* Chapter 1 :draft:\n:PROPERTIES:\n:CUSTOM_ID: chapter-1\n:ID: 6f1c2b\n:AUTHOR: someone\n:LANG: en\n:END:\n** Section A\n:PROPERTIES:\n:LANG: fr\n:END:\nSection A text.\n#+BEGIN_SRC org\n* not a headline\n#+END_SRC\n*** Details\nMore text.\n** Section B\nSection B text.\n* Chapter 2\n"
+
+	testCases := map[string]struct {
+		path     []string
+		ok       bool
+		expected string
+	}{
+		"nested": {
+			[]string{"Chapter 1", "Section A"},
+			true,
+			"#+TITLE: a book\n#+FILETAGS: :book:\n* Section A :draft:\n:PROPERTIES:\n:LANG: fr\n:AUTHOR: someone\n:END:\nSection A text.\n#+BEGIN_SRC org\n* not a headline\n#+END_SRC\n** Details\nMore text.\n",
+		},
+		"top-level": {
+			[]string{"Chapter 2"},
+			true,
+			"#+TITLE: a book\n#+FILETAGS: :book:\n* Chapter 2\n",
+		},
+		"no-drawer-inherits": {
+			[]string{"Chapter 1", "Section B"},
+			true,
+			"#+TITLE: a book\n#+FILETAGS: :book:\n* Section B :draft:\n:PROPERTIES:\n:AUTHOR: someone\n:LANG: en\n:END:\nSection B text.\n",
+		},
+		"non-existent": {
+			[]string{"Chapter 1", "Section C"},
+			false,
+			"",
+		},
+		"not-an-outline-path": {
+			[]string{"Section A"},
+			false,
+			"",
+		},
+	}
+
+	for caseName, tc := range testCases {
+		out, ok := Subtree([]byte(in), tc.path)
+		if ok != tc.ok {
+			t.Errorf("case %s for Subtree(%v) ok = %t\nwants: %t", caseName, tc.path, ok, tc.ok)
+			continue
+		}
+		if !bytes.Equal(out, []byte(tc.expected)) {
+			t.Errorf("case %s for Subtree(%v) = %s\nwants: %s", caseName, tc.path, out, tc.expected)
+		}
+	}
+}
+
+func TestSubtreeRendersStandalone(t *testing.T) {
+	in := "* Chapter 1\n** Section A\nSome /text/.\n"
+	expected := "<h1 id=\"section-a\">Section A</h1>\n\n<p>Some <em>text</em>.</p>\n"
+
+	sub, ok := Subtree([]byte(in), []string{"Chapter 1", "Section A"})
+	if !ok {
+		t.Fatalf("Subtree() did not find the Section A headline")
+	}
+	if out := OrgCommon(sub); !bytes.Equal(out, []byte(expected)) {
+		t.Errorf("OrgCommon() of Subtree() = %s\nwants: %s", out, expected)
+	}
+}
+
+func TestSubtreeNestedBlocks(t *testing.T) {
+	if _, ok := Subtree([]byte(nestedBlockIn), []string{"Fake"}); ok {
+		t.Errorf("Subtree() from %s found the Fake headline inside an example block", nestedBlockIn)
+	}
+	expected := "* Real\nsee [[*Fake]] and[fn:x]\n"
+	if out, ok := Subtree([]byte(nestedBlockIn), []string{"Real"}); !ok || string(out) != expected {
+		t.Errorf("Subtree() from %s = %q, %t\nwants: %q, true", nestedBlockIn, out, ok, expected)
+	}
+}
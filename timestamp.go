@@ -0,0 +1,95 @@
+package goorgeous
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Timestamp is an org timestamp such as <2006-01-02 Mon 15:04> or [2006-01-02 Mon]
+type Timestamp struct {
+	// Active is true for <...> timestamps and false for inactive [...] ones
+	Active bool
+	// Date is the date in the form 2006-01-02
+	Date string
+	// StartTime is the time of day in the form 15:04, or empty for a date-only timestamp
+	StartTime string
+	// EndTime is the end of a time range within the day, such as the 11:00 of
+	// <2006-01-02 Mon 09:00-11:00>, or empty when the timestamp has a single time
+	EndTime string
+	// Repeater holds any repeater or warning delay cookies, such as +1w, as written
+	Repeater string
+	// Location is used to interpret Date and StartTime; nil means time.Local
+	Location *time.Location
+}
+
+var reTimestamp = regexp.MustCompile(`^([<\[])(\d{4}-\d{2}-\d{2})(?:\s+[^\s\d>\]+.-]+)?(?:\s+(\d{1,2}:\d{2})(?:-(\d{1,2}:\d{2}))?)?((?:\s+[.+-]{1,2}\d+[hdwmy])*)\s*([>\]])$`)
+
+// ParseTimestamp parses a byte slice holding a single org timestamp. The date and time
+// are only checked for their shape; use Time to find out if they are a real moment.
+func ParseTimestamp(data []byte) (*Timestamp, bool) {
+	matches := reTimestamp.FindSubmatch(data)
+	if matches == nil {
+		return nil, false
+	}
+
+	opener, closer := matches[1][0], matches[6][0]
+	if (opener == '<') != (closer == '>') {
+		return nil, false
+	}
+
+	return &Timestamp{
+		Active:    opener == '<',
+		Date:      string(matches[2]),
+		StartTime: string(matches[3]),
+		EndTime:   string(matches[4]),
+		Repeater:  strings.TrimSpace(string(matches[5])),
+	}, true
+}
+
+// Time returns the moment the timestamp refers to in its Location. A date-only
+// timestamp returns midnight of that day. ok is false when the date or time is not valid.
+func (t *Timestamp) Time() (tm time.Time, ok bool) {
+	loc := t.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	layout, value := "2006-01-02", t.Date
+	if t.StartTime != "" {
+		layout, value = "2006-01-02 15:04", t.Date+" "+padClock(t.StartTime)
+	}
+
+	tm, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return tm, true
+}
+
+// Duration returns the length of the time range within the day of a timestamp such as
+// <2006-01-02 Mon 09:00-11:00>. ok is false when the timestamp has no EndTime, when
+// either time is not valid or when the range ends before it starts.
+func (t *Timestamp) Duration() (d time.Duration, ok bool) {
+	if t.StartTime == "" || t.EndTime == "" {
+		return 0, false
+	}
+
+	start, err := time.Parse("15:04", padClock(t.StartTime))
+	if err != nil {
+		return 0, false
+	}
+	end, err := time.Parse("15:04", padClock(t.EndTime))
+	if err != nil || end.Before(start) {
+		return 0, false
+	}
+	return end.Sub(start), true
+}
+
+// padClock turns a clock like 9:00 into 09:00
+func padClock(clock string) string {
+	if len(clock) == 4 {
+		return "0" + clock
+	}
+	return clock
+}
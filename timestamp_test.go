@@ -0,0 +1,96 @@
+package goorgeous
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		ok       bool
+		expected Timestamp
+	}{
+		"active-date":      {"<2023-01-02 Mon>", true, Timestamp{Active: true, Date: "2023-01-02"}},
+		"inactive-date":    {"[2023-01-02 Mon]", true, Timestamp{Date: "2023-01-02"}},
+		"no-day-name":      {"<2023-01-02>", true, Timestamp{Active: true, Date: "2023-01-02"}},
+		"timed":            {"<2023-01-02 Mon 09:30>", true, Timestamp{Active: true, Date: "2023-01-02", StartTime: "09:30"}},
+		"time-range":       {"<2023-01-02 Mon 09:00-11:00>", true, Timestamp{Active: true, Date: "2023-01-02", StartTime: "09:00", EndTime: "11:00"}},
+		"time-range-plain": {"[2023-01-02 Mon 9:00-9:45 +1d]", true, Timestamp{Date: "2023-01-02", StartTime: "9:00", EndTime: "9:45", Repeater: "+1d"}},
+		"repeater":         {"<2023-01-02 Mon 9:30 +1w -2d>", true, Timestamp{Active: true, Date: "2023-01-02", StartTime: "9:30", Repeater: "+1w -2d"}},
+		"mismatched":       {"<2023-01-02 Mon]", false, Timestamp{}},
+		"not-a-timestamp":  {"<next monday>", false, Timestamp{}},
+		"trailing-content": {"<2023-01-02 Mon> later", false, Timestamp{}},
+	}
+
+	for caseName, tc := range testCases {
+		ts, ok := ParseTimestamp([]byte(tc.in))
+		if ok != tc.ok {
+			t.Errorf("case %s for ParseTimestamp(%s) ok = %t\nwants: %t", caseName, tc.in, ok, tc.ok)
+			continue
+		}
+		if ok && *ts != tc.expected {
+			t.Errorf("case %s for ParseTimestamp(%s) = %+v\nwants: %+v", caseName, tc.in, *ts, tc.expected)
+		}
+	}
+}
+
+func TestTimestampTime(t *testing.T) {
+	loc := time.FixedZone("test", 2*60*60)
+	testCases := map[string]struct {
+		in       string
+		ok       bool
+		expected time.Time
+	}{
+		"dated":         {"<2023-01-02 Mon>", true, time.Date(2023, 1, 2, 0, 0, 0, 0, loc)},
+		"timed":         {"<2023-01-02 Mon 09:30>", true, time.Date(2023, 1, 2, 9, 30, 0, 0, loc)},
+		"single-digit":  {"[2023-01-02 Mon 9:05]", true, time.Date(2023, 1, 2, 9, 5, 0, 0, loc)},
+		"invalid-date":  {"<2023-13-45 Mon>", false, time.Time{}},
+		"invalid-clock": {"<2023-01-02 Mon 25:61>", false, time.Time{}},
+	}
+
+	for caseName, tc := range testCases {
+		ts, found := ParseTimestamp([]byte(tc.in))
+		if !found {
+			t.Fatalf("case %s for ParseTimestamp(%s) did not find a timestamp", caseName, tc.in)
+		}
+		ts.Location = loc
+
+		tm, ok := ts.Time()
+		if ok != tc.ok || !tm.Equal(tc.expected) {
+			t.Errorf("case %s for Time() from %s = %s, %t\nwants: %s, %t", caseName, tc.in, tm, ok, tc.expected, tc.ok)
+		}
+	}
+
+	ts, _ := ParseTimestamp([]byte("<2023-01-02 Mon>"))
+	if tm, _ := ts.Time(); tm.Location() != time.Local {
+		t.Errorf("Time() with no Location = %s\nwants the time.Local location", tm.Location())
+	}
+}
+
+func TestTimestampDuration(t *testing.T) {
+	testCases := map[string]struct {
+		in       string
+		ok       bool
+		expected time.Duration
+	}{
+		"time-range":    {"<2023-01-02 Mon 09:00-11:00>", true, 2 * time.Hour},
+		"single-digit":  {"<2023-01-02 Mon 9:15-10:00>", true, 45 * time.Minute},
+		"single-time":   {"<2023-01-02 Mon 09:00>", false, 0},
+		"dated":         {"<2023-01-02 Mon>", false, 0},
+		"ends-before":   {"<2023-01-02 Mon 11:00-09:00>", false, 0},
+		"invalid-clock": {"<2023-01-02 Mon 09:00-25:00>", false, 0},
+	}
+
+	for caseName, tc := range testCases {
+		ts, found := ParseTimestamp([]byte(tc.in))
+		if !found {
+			t.Fatalf("case %s for ParseTimestamp(%s) did not find a timestamp", caseName, tc.in)
+		}
+
+		d, ok := ts.Duration()
+		if ok != tc.ok || d != tc.expected {
+			t.Errorf("case %s for Duration() from %s = %s, %t\nwants: %s, %t", caseName, tc.in, d, ok, tc.expected, tc.ok)
+		}
+	}
+}